@@ -0,0 +1,277 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"eonza/lib"
+	"eonza/script"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+	"github.com/labstack/echo/v4"
+	md "github.com/labstack/echo/v4/middleware"
+)
+
+// agentTask is the task running on the agent
+type agentTask struct {
+	Status  int
+	process *os.Process
+}
+
+var (
+	runningTasks = make(map[uint32]*agentTask)
+	agentMutex   = &sync.Mutex{}
+)
+
+// agentForwards are the local API commands of tasks which are sent to the main server
+var agentForwards = map[string]bool{
+	`taskstatus`:   true,
+	`notification`: true,
+	`runscript`:    true,
+	`extqueue`:     true,
+}
+
+func agentAuthHandle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !agentAccess(c) {
+			return AccessDenied(http.StatusForbidden)
+		}
+		return next(c)
+	}
+}
+
+func agentTaskID(c echo.Context) (uint32, *agentTask) {
+	id, _ := strconv.ParseUint(c.Param(`id`), 10, 32)
+	agentMutex.Lock()
+	defer agentMutex.Unlock()
+	return uint32(id), runningTasks[uint32(id)]
+}
+
+// agentRunHandle starts the task process of the script received from the main server
+func agentRunHandle(c echo.Context) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	scriptData, err := script.Decode(body)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	header := &scriptData.Header
+	header.LogDir = cfg.Log.Dir
+	header.AssetsDir = cfg.AssetsDir
+	header.PackagesDir = cfg.PackagesDir
+	if len(header.PkgPath) > 0 {
+		header.PkgPath = filepath.Join(cfg.PackagesDir, filepath.Base(header.PkgPath))
+	}
+	header.ServerPort = cfg.HTTP.LocalPort
+	header.HTTP.Open = false
+	data, err := script.EncodeScript(scriptData)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	command, err := script.Start(data)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	agentMutex.Lock()
+	runningTasks[header.TaskID] = &agentTask{
+		Status:  TaskActive,
+		process: command.Process,
+	}
+	agentMutex.Unlock()
+	go agentWait(header.TaskID, command)
+	return jsonSuccess(c)
+}
+
+// agentWait waits for the end of the task process and sends the task files to the main server
+func agentWait(taskID uint32, command *exec.Cmd) {
+	command.Wait()
+	os.Remove(taskSocket(taskID))
+	os.Remove(localSocket(taskID))
+	agentMutex.Lock()
+	atask := runningTasks[taskID]
+	delete(runningTasks, taskID)
+	agentMutex.Unlock()
+
+	zipFile := filepath.Join(cfg.Log.Dir, fmt.Sprintf(`%08x.zip`, taskID))
+	if data, err := os.ReadFile(zipFile); err == nil {
+		if err = agentResult(agentRequest(http.MethodPost, fmt.Sprintf(`%s/agent/upload/%d`,
+			cfg.Agent.Server, taskID), bytes.NewReader(data), `application/zip`)); err != nil {
+			golog.Error(err)
+		} else {
+			os.Remove(zipFile)
+		}
+	}
+	if atask.Status < TaskFinished {
+		// the process has exited without sending the final status
+		if err := agentForward(`taskstatus`, TaskStatus{
+			TaskID:  taskID,
+			Status:  TaskCrashed,
+			Message: `the task process has exited unexpectedly`,
+			Time:    time.Now().Unix(),
+		}); err != nil {
+			golog.Error(err)
+		}
+	}
+}
+
+// agentProxyHandle passes the request of the main server to the web-server of the task
+func agentProxyHandle(c echo.Context) error {
+	id, atask := agentTaskID(c)
+	if atask == nil {
+		return AccessDenied(http.StatusNotFound)
+	}
+	path := c.Param(`*`)
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = `http`
+			req.URL.Host = fmt.Sprintf(`%08x`, id)
+			req.URL.Path = `/` + path
+			req.URL.RawPath = ``
+			req.Header.Del(echo.HeaderAuthorization)
+		},
+		Transport: taskTransport,
+	}
+	proxy.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// agentLocalHandle passes the request of the main server to the local web-server of the task
+func agentLocalHandle(c echo.Context) error {
+	id, atask := agentTaskID(c)
+	if atask == nil {
+		return AccessDenied(http.StatusNotFound)
+	}
+	url := c.Param(`*`)
+	if query := c.QueryString(); len(query) > 0 {
+		url += `?` + query
+	}
+	body, err := localGet(id, url)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, body)
+}
+
+func agentKillHandle(c echo.Context) error {
+	id, atask := agentTaskID(c)
+	if atask == nil {
+		return jsonError(c, fmt.Errorf(`task %d has not been found`, id))
+	}
+	if err := atask.process.Kill(); err != nil {
+		return jsonError(c, err)
+	}
+	return jsonSuccess(c)
+}
+
+// agentForward sends the local API command of the task to the main server
+func agentForward(cmd string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return agentResult(agentRequest(http.MethodPost, fmt.Sprintf(`%s/agent/%s`, cfg.Agent.Server,
+		cmd), bytes.NewReader(body), echo.MIMEApplicationJSON))
+}
+
+// agentForwardHandle passes the local API request of the task to the main server
+func agentForwardHandle(c echo.Context) error {
+	cmd := c.Param(`cmd`)
+	if !agentForwards[cmd] {
+		return AccessDenied(http.StatusNotFound)
+	}
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	if cmd == `taskstatus` {
+		var taskStatus TaskStatus
+		if err = json.Unmarshal(body, &taskStatus); err == nil {
+			agentMutex.Lock()
+			if atask := runningTasks[taskStatus.TaskID]; atask != nil {
+				atask.Status = taskStatus.Status
+			}
+			agentMutex.Unlock()
+		}
+	}
+	answer, err := agentRequest(http.MethodPost, fmt.Sprintf(`%s/agent/%s`, cfg.Agent.Server, cmd),
+		bytes.NewReader(body), echo.MIMEApplicationJSON)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, answer)
+}
+
+// agentHeartbeat registers the agent on the main server periodically
+func agentHeartbeat() {
+	info := AgentInfo{
+		Name:    cfg.Agent.Name,
+		URL:     strings.TrimRight(cfg.Agent.URL, `/`),
+		Labels:  cfg.Agent.Labels,
+		Version: GetVersion(),
+	}
+	for !isShutdown {
+		if err := agentForward(`register`, info); err != nil {
+			golog.Warn(err)
+		}
+		time.Sleep(AgentPing * time.Second)
+	}
+}
+
+// RunAgent starts the web-server of the agent which runs the scripts of the main server
+func RunAgent() *echo.Echo {
+	cfg.Agent.Server = strings.TrimRight(cfg.Agent.Server, `/`)
+	if len(cfg.Agent.Name) == 0 {
+		cfg.Agent.Name, _ = os.Hostname()
+	}
+	if len(cfg.Agent.URL) == 0 {
+		protocol := `https`
+		if lib.IsPrivateHost(cfg.HTTP.Host) {
+			protocol = `http`
+		}
+		cfg.Agent.URL = fmt.Sprintf(`%s://%s:%d`, protocol, cfg.HTTP.Host, cfg.HTTP.Port)
+	}
+	e := echo.New()
+
+	e.HideBanner = true
+	e.Use(Logger)
+	e.Use(md.Recover())
+	e.GET("/ping", pingHandle)
+
+	agent := e.Group("/agent", agentAuthHandle)
+	agent.POST("/run", agentRunHandle)
+	agent.GET("/kill/:id", agentKillHandle)
+	agent.GET("/local/:id/*", agentLocalHandle)
+	agent.Any("/task/:id/*", agentProxyHandle)
+
+	RunLocalServer(cfg.HTTP.LocalPort)
+	go func() {
+		var err error
+		if lib.IsPrivateHost(cfg.HTTP.Host) {
+			err = e.Start(fmt.Sprintf(":%d", cfg.HTTP.Port))
+		} else {
+			err = e.StartTLS(fmt.Sprintf(":%d", cfg.HTTP.Port), cfg.HTTP.Cert, cfg.HTTP.Priv)
+		}
+		if err != nil && !isShutdown {
+			golog.Fatal(err)
+		}
+	}()
+	go agentHeartbeat()
+	golog.Infof(`Agent %s has been started`, cfg.Agent.Name)
+	return e
+}
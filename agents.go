@@ -0,0 +1,384 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kataras/golog"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// AgentPing is the interval of agent heartbeats in seconds
+	AgentPing = 15
+	// AgentOffline is the time without heartbeats after which the agent is offline in seconds
+	AgentOffline = 3 * AgentPing
+	// AgentTimeout is the timeout of requests between the main server and agents in seconds
+	AgentTimeout = 30
+	// AgentUploadLimit is the maximum size of the archive of the task files uploaded by the agent
+	AgentUploadLimit = 256 << 20
+)
+
+// AgentInfo contains the registration data of the agent
+type AgentInfo struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Labels  []string `json:"labels,omitempty"`
+	Version string   `json:"version,omitempty"`
+}
+
+type AgentItem struct {
+	AgentInfo
+	Online   bool   `json:"online"`
+	LastSeen string `json:"lastseen,omitempty"`
+	Tasks    int    `json:"tasks"` // the number of running tasks
+}
+
+type AgentsResponse struct {
+	List  []AgentItem `json:"list"`
+	Error string      `json:"error,omitempty"`
+}
+
+var (
+	agentSeen   = make(map[string]time.Time)
+	agentClient = &http.Client{Timeout: AgentTimeout * time.Second}
+	// agentsMutex protects the agent list which is read by the streams and the proxies of tasks
+	// without locking the global mutex
+	agentsMutex = &sync.Mutex{}
+)
+
+// agentAccess checks the shared token of the request
+func agentAccess(c echo.Context) bool {
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	return len(cfg.Agent.Token) > 0 &&
+		subtle.ConstantTimeCompare([]byte(auth), []byte(`Bearer `+cfg.Agent.Token)) == 1
+}
+
+// agentRequest sends the request with the shared token to the main server or to the agent
+func agentRequest(method, url string, body io.Reader, contentType string) ([]byte, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(echo.HeaderAuthorization, `Bearer `+cfg.Agent.Token)
+	if len(contentType) > 0 {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	resp, err := agentClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(`%s: unexpected response %s`, url, resp.Status)
+	}
+	return data, nil
+}
+
+func agentResult(data []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	var resp Response
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+	if len(resp.Error) > 0 {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+func agentOnline(name string) bool {
+	return time.Since(agentSeen[name]) < AgentOffline*time.Second
+}
+
+// agentTasks returns the number of running tasks of the agent, the global mutex must be locked
+func agentTasks(name string) (count int) {
+	for _, item := range tasks {
+		if item.Agent == name && item.Status < TaskFinished {
+			count++
+		}
+	}
+	return
+}
+
+// pickAgent returns the online agent with the name or the label which has the fewest running tasks
+func pickAgent(target string) (*AgentInfo, error) {
+	var (
+		ret   *AgentInfo
+		count int
+	)
+	agentsMutex.Lock()
+	defer agentsMutex.Unlock()
+	for _, agent := range storage.Agents {
+		if !agentOnline(agent.Name) {
+			continue
+		}
+		matched := agent.Name == target
+		for _, label := range agent.Labels {
+			matched = matched || label == target
+		}
+		if !matched {
+			continue
+		}
+		if running := agentTasks(agent.Name); ret == nil || running < count ||
+			(running == count && agent.Name < ret.Name) {
+			ret = agent
+			count = running
+		}
+	}
+	if ret == nil {
+		return nil, fmt.Errorf(`there is no available agent %s`, target)
+	}
+	return ret, nil
+}
+
+// agentClaimKey returns the random key of the tokens of the task running on the agent
+func agentClaimKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return ``, err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// taskClaimKey returns the key of the tokens which are checked by the web-server of the task
+func taskClaimKey(ptask *Task) string {
+	if len(ptask.claimKey) > 0 {
+		return ptask.claimKey
+	}
+	return cfg.HTTP.JWTKey + sessionKey
+}
+
+// taskToken returns the short-lived token of the user for the web-server of the task
+func taskToken(ptask *Task, claims *Claims) (string, error) {
+	claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(
+		[]byte(taskClaimKey(ptask)))
+}
+
+func getAgent(name string) (*AgentInfo, error) {
+	agentsMutex.Lock()
+	defer agentsMutex.Unlock()
+	if agent, ok := storage.Agents[name]; ok {
+		return agent, nil
+	}
+	return nil, fmt.Errorf(`agent %s has not been found`, name)
+}
+
+// Run sends the encoded script to the agent which starts the task process
+func (agent *AgentInfo) Run(data *bytes.Buffer) error {
+	return agentResult(agentRequest(http.MethodPost, agent.URL+`/agent/run`, data,
+		echo.MIMEOctetStream))
+}
+
+// runAgentTask sends the encoded script of the registered task to the agent. The global mutex is
+// unlocked during the request so the slow agent does not stall the main web-server. The task is
+// failed if the agent has not started it.
+func runAgentTask(agent *AgentInfo, taskID uint32, data *bytes.Buffer) error {
+	mutex.Unlock()
+	err := agent.Run(data)
+	mutex.Lock()
+	if err != nil {
+		if errStatus := SetTaskStatus(TaskStatus{
+			TaskID:  taskID,
+			Status:  TaskFailed,
+			Message: err.Error(),
+			Time:    time.Now().Unix(),
+		}); errStatus != nil {
+			golog.Error(errStatus)
+		}
+	}
+	return err
+}
+
+// taskGet sends the request to the local web-server of the task
+func taskGet(ptask *Task, url string) ([]byte, error) {
+	if len(ptask.Agent) == 0 {
+		return localGet(ptask.ID, url)
+	}
+	agent, err := getAgent(ptask.Agent)
+	if err != nil {
+		return nil, err
+	}
+	return agentRequest(http.MethodGet, fmt.Sprintf(`%s/agent/local/%d/%s`, agent.URL, ptask.ID,
+		url), nil, ``)
+}
+
+// killAgentTask kills the process of the task on the agent
+func killAgentTask(ptask *Task) bool {
+	agent, err := getAgent(ptask.Agent)
+	if err != nil {
+		return false
+	}
+	return agentResult(agentRequest(http.MethodGet, fmt.Sprintf(`%s/agent/kill/%d`, agent.URL,
+		ptask.ID), nil, ``)) == nil
+}
+
+// proxyAgentTask passes the request to the web-server of the task through the agent. The token
+// of the user is replaced with the token signed by the key of the task.
+func proxyAgentTask(c echo.Context, ptask *Task, path, ip string) error {
+	agent, err := getAgent(ptask.Agent)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	user := c.(*Auth).User
+	token, err := taskToken(ptask, &Claims{
+		Counter: user.PassCounter,
+		UserID:  user.ID,
+		RoleID:  user.RoleID,
+	})
+	if err != nil {
+		return jsonError(c, err)
+	}
+	target, err := url.Parse(agent.URL)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = fmt.Sprintf(`/agent/task/%d/%s`, ptask.ID, path)
+			req.URL.RawPath = ``
+			req.Header.Set(XForwardedFor, ip)
+			req.Header.Del(XRealIP)
+			req.Header.Set(echo.HeaderAuthorization, `Bearer `+cfg.Agent.Token)
+			req.Header.Set(`Cookie`, `jwt=`+token)
+		},
+	}
+	proxy.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// agentHandle allows the agents to call the local API of the main server
+func agentHandle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !agentAccess(c) {
+			return AccessDenied(http.StatusForbidden)
+		}
+		return next(c)
+	}
+}
+
+func agentRegisterHandle(c echo.Context) error {
+	var info AgentInfo
+	if err := c.Bind(&info); err != nil {
+		return jsonError(c, err)
+	}
+	if len(info.Name) == 0 || len(info.URL) == 0 {
+		return jsonError(c, fmt.Errorf(`invalid agent registration`))
+	}
+	agentsMutex.Lock()
+	agentSeen[info.Name] = time.Now()
+	prev, ok := storage.Agents[info.Name]
+	changed := !ok || prev.URL != info.URL || prev.Version != info.Version ||
+		strings.Join(prev.Labels, `,`) != strings.Join(info.Labels, `,`)
+	if changed {
+		storage.Agents[info.Name] = &info
+	}
+	agentsMutex.Unlock()
+	if changed {
+		if err := SaveStorage(); err != nil {
+			return jsonError(c, err)
+		}
+	}
+	return jsonSuccess(c)
+}
+
+// agentUploadHandle saves the archive of the task files which has been finished on the agent
+func agentUploadHandle(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param(`id`), 10, 32)
+	// AuthHandle has locked the mutex for the request
+	ptask := tasks[uint32(id)]
+	if ptask == nil || len(ptask.Agent) == 0 {
+		return jsonError(c, fmt.Errorf(`task %d has not been found`, id))
+	}
+	taskID := ptask.ID
+	// the archive can be large so it is received without locking other requests
+	mutex.Unlock()
+	defer mutex.Lock()
+	data, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, AgentUploadLimit))
+	if err != nil {
+		return jsonError(c, err)
+	}
+	if err = os.WriteFile(filepath.Join(cfg.Log.Dir, fmt.Sprintf(`%08x.zip`, taskID)), data,
+		0666); err != nil {
+		return jsonError(c, err)
+	}
+	return jsonSuccess(c)
+}
+
+func agentsResponse(c echo.Context) error {
+	agentsMutex.Lock()
+	list := make([]AgentItem, 0, len(storage.Agents))
+	for _, agent := range storage.Agents {
+		item := AgentItem{
+			AgentInfo: *agent,
+			Online:    agentOnline(agent.Name),
+			Tasks:     agentTasks(agent.Name),
+		}
+		if seen, ok := agentSeen[agent.Name]; ok {
+			item.LastSeen = seen.Format(TimeFormat)
+		}
+		list = append(list, item)
+	}
+	agentsMutex.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return c.JSON(http.StatusOK, &AgentsResponse{
+		List: list,
+	})
+}
+
+func agentsHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	return agentsResponse(c)
+}
+
+func removeAgentHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	name := c.Param(`name`)
+	agentsMutex.Lock()
+	_, ok := storage.Agents[name]
+	delete(storage.Agents, name)
+	delete(agentSeen, name)
+	agentsMutex.Unlock()
+	if ok {
+		if err := SaveStorage(); err != nil {
+			return jsonError(c, err)
+		}
+	}
+	return agentsResponse(c)
+}
@@ -31,16 +31,25 @@ type CompileResponse struct {
 
 type RunResponse struct {
 	Success bool   `json:"success"`
-	Port    int    `json:"port"`
+	Port    int    `json:"port"` // the port of the web-server which serves URL
+	URL     string `json:"url,omitempty"`
 	ID      uint32 `json:"id"`
+	Queued  bool   `json:"queued,omitempty"`
 	Error   string `json:"error,omitempty"`
 }
 
+// runResponse returns the answer to the request of the started or queued run
+func runResponse(rs *RunScript) *RunResponse {
+	return &RunResponse{Success: true, Port: cfg.HTTP.Port, URL: taskURL(rs.ID), ID: rs.ID,
+		Queued: rs.Queued}
+}
+
 type TaskStatus struct {
-	TaskID  uint32 `json:"taskid"`
-	Status  int    `json:"status"`
-	Message string `json:"msg,omitempty"`
-	Time    int64  `json:"time,omitempty"`
+	TaskID  uint32            `json:"taskid"`
+	Status  int               `json:"status"`
+	Message string            `json:"msg,omitempty"`
+	Time    int64             `json:"time,omitempty"`
+	Results map[string]string `json:"results,omitempty"` // result variables of the finished task
 }
 
 type TaskInfo struct {
@@ -51,19 +60,26 @@ type TaskInfo struct {
 	FinishTime string `json:"finish"`
 	//	UserID     uint32 `json:"userid"`
 	//	RoleID     uint32 `json:"roleid"`
-	User    string `json:"user"`
-	Role    string `json:"role"`
-	ToDel   bool   `json:"todel"`
-	Locked  bool   `json:"locked"`
-	Port    int    `json:"port"`
-	Message string `json:"message,omitempty"`
+	User      string     `json:"user"`
+	Role      string     `json:"role"`
+	ToDel     bool       `json:"todel"`
+	Locked    bool       `json:"locked"`
+	URL       string     `json:"url,omitempty"`
+	Message   string     `json:"message,omitempty"`
+	RetryOf   uint32     `json:"retryof,omitempty"`
+	Attempt   int        `json:"attempt,omitempty"`
+	RestartOf uint32     `json:"restartof,omitempty"`
+	Agent     string     `json:"agent,omitempty"`
+	Usage     *TaskUsage `json:"usage,omitempty"`
 }
 
 type TasksResponse struct {
-	List     []TaskInfo `json:"list,omitempty"`
-	Page     int        `json:"page"`
-	AllPages int        `json:"allpages"`
-	Error    string     `json:"error,omitempty"`
+	List     []TaskInfo  `json:"list,omitempty"`
+	Page     int         `json:"page"`
+	AllPages int         `json:"allpages"`
+	Total    int         `json:"total"`
+	Queue    []QueueInfo `json:"queue"`
+	Error    string      `json:"error,omitempty"`
 }
 
 type Feedback struct {
@@ -146,7 +162,7 @@ func runHandle(c echo.Context) error {
 	if console {
 		return c.Blob(http.StatusOK, ``, rs.Encoded)
 	}
-	return c.JSON(http.StatusOK, RunResponse{Success: true, Port: rs.Port, ID: rs.ID})
+	return c.JSON(http.StatusOK, runResponse(&rs))
 }
 
 func pingHandle(c echo.Context) error {
@@ -168,8 +184,12 @@ func sysTaskHandle(c echo.Context) error {
 			if user.RoleID != users.XAdminID && user.ID != item.UserID {
 				return jsonError(c, fmt.Errorf(`Access denied`))
 			}
+			if cmd == fmt.Sprint(gentee.SysTerminate) {
+				CancelTask(item)
+				break
+			}
 			go func() {
-				lib.LocalGet(item.LocalPort, fmt.Sprintf("sys?cmd=%s&taskid=%d", cmd, taskid))
+				taskGet(item, fmt.Sprintf("sys?cmd=%s&taskid=%d", cmd, taskid))
 			}()
 			break
 		}
@@ -177,11 +197,61 @@ func sysTaskHandle(c echo.Context) error {
 	return jsonSuccess(c)
 }
 
+// taskFilter gets the filter of the task list from the query parameters
+func taskFilter(c echo.Context) (filter TaskFilter, err error) {
+	filter.Name = strings.TrimSpace(c.QueryParam(`name`))
+	filter.User = strings.TrimSpace(c.QueryParam(`user`))
+	filter.Role = strings.TrimSpace(c.QueryParam(`role`))
+	if status := c.QueryParam(`status`); len(status) > 0 {
+		for _, item := range strings.Split(status, `,`) {
+			var istatus int
+			if istatus, err = ParseTaskStatus(item); err != nil {
+				return
+			}
+			filter.Status = append(filter.Status, istatus)
+		}
+	}
+	for _, item := range []struct {
+		Name string
+		End  bool
+		Val  *int64
+	}{
+		{`startfrom`, false, &filter.StartFrom},
+		{`startto`, true, &filter.StartTo},
+		{`finishfrom`, false, &filter.FinishFrom},
+		{`finishto`, true, &filter.FinishTo},
+	} {
+		if *item.Val, err = ParseFilterTime(c.QueryParam(item.Name), item.End); err != nil {
+			return
+		}
+	}
+	if retryOf := c.QueryParam(`retryof`); len(retryOf) > 0 {
+		var id uint64
+		if id, err = strconv.ParseUint(retryOf, 10, 32); err != nil {
+			return
+		}
+		filter.RetryOf = uint32(id)
+	}
+	switch sortBy := c.QueryParam(`sort`); sortBy {
+	case ``, `start`, `finish`, `name`, `status`, `duration`:
+		filter.Sort = sortBy
+	default:
+		err = fmt.Errorf(`invalid sort field '%s'`, sortBy)
+		return
+	}
+	filter.Asc = c.QueryParam(`order`) == `asc`
+	return
+}
+
 func tasksHandle(c echo.Context) error {
 	if err := CheckTasks(); err != nil {
 		return jsonError(c, err)
 	}
-	list := ListTasks()
+	filter, err := taskFilter(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	list := QueryTasks(filter)
 	page := 1
 	allpages := 1
 	listInfo := make([]TaskInfo, 0, len(list))
@@ -202,9 +272,7 @@ func tasksHandle(c echo.Context) error {
 		} else if user.RoleID != users.XAdminID && user.ID != item.UserID {
 			continue
 		}
-		if user.RoleID == users.XAdminID || (taskFlag&4 == 4) ||
-			(taskFlag&1 == 1 && user.ID == item.UserID) ||
-			(taskFlag&2 == 2 && user.RoleID == item.RoleID) {
+		if taskVisible(user, taskFlag, item) {
 			todel := user.RoleID == users.XAdminID || (taskFlag&0x400 == 0x400) ||
 				(taskFlag&0x100 == 0x100 && user.ID == item.UserID) ||
 				(taskFlag&0x200 == 0x200 && user.RoleID == item.RoleID)
@@ -227,9 +295,14 @@ func tasksHandle(c echo.Context) error {
 				User:       userName,
 				Role:       roleName,
 				Locked:     item.Locked,
-				Port:       item.Port,
+				URL:        taskURL(item.ID),
 				ToDel:      todel,
 				Message:    item.Message,
+				RetryOf:    item.RetryOf,
+				Attempt:    item.Attempt,
+				RestartOf:  item.RestartOf,
+				Agent:      item.Agent,
+				Usage:      item.Usage,
 			})
 		}
 	}
@@ -253,6 +326,8 @@ func tasksHandle(c echo.Context) error {
 		List:     listInfo[start:end],
 		Page:     page,
 		AllPages: allpages,
+		Total:    len(listInfo),
+		Queue:    queueList(user),
 	})
 }
 
@@ -288,15 +363,15 @@ func taskAction(c echo.Context, lock bool) error {
 	}
 	if lock {
 		ptask.Locked = !ptask.Locked
+		if errSave := SaveTrace(ptask); errSave != nil {
+			golog.Error(errSave)
+		}
 	} else {
 		if ptask.Locked {
 			return jsonError(c, fmt.Errorf(`Access denied`))
 		}
 		RemoveTask(uint32(idTask))
 	}
-	if errSave := SaveTasks(); errSave != nil {
-		golog.Error(errSave)
-	}
 	return tasksHandle(c)
 }
 
@@ -0,0 +1,109 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"eonza/script"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type zipFileReader struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+}
+
+func (reader *zipFileReader) Close() error {
+	reader.ReadCloser.Close()
+	return reader.archive.Close()
+}
+
+// openTaskFile opens the file of the task in the log directory or in the task archive
+func openTaskFile(id uint32, name string) (io.ReadCloser, error) {
+	if f, err := os.Open(filepath.Join(cfg.Log.Dir, name)); err == nil {
+		return f, nil
+	}
+	archive, err := zip.OpenReader(filepath.Join(cfg.Log.Dir, fmt.Sprintf(`%08x.zip`, id)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range archive.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				archive.Close()
+				return nil, err
+			}
+			return &zipFileReader{ReadCloser: rc, archive: archive}, nil
+		}
+	}
+	archive.Close()
+	return nil, os.ErrNotExist
+}
+
+// ParseArtifacts converts the content of the artifact list file
+func ParseArtifacts(data string) (ret []script.Artifact) {
+	if len(data) > 0 {
+		if err := json.Unmarshal([]byte(data), &ret); err != nil {
+			ret = nil
+		}
+	}
+	return
+}
+
+// TaskArtifacts returns the files which have been attached to the task
+func TaskArtifacts(id uint32) []script.Artifact {
+	rc, err := openTaskFile(id, fmt.Sprintf(`%08x.%s`, id, TaskExt[TExtArtifact]))
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil
+	}
+	return ParseArtifacts(string(data))
+}
+
+// removeArtifacts deletes the artifact files which have not been archived
+func removeArtifacts(id uint32) {
+	list, _ := filepath.Glob(filepath.Join(cfg.Log.Dir, fmt.Sprintf(`%08x.*.%s`, id,
+		script.ArtifactFile)))
+	for _, fname := range list {
+		os.Remove(fname)
+	}
+}
+
+func artifactHandle(c echo.Context) error {
+	ptask, _, err := showTaskAccess(c, c.Param(`id`))
+	if err != nil {
+		return err
+	}
+	index, err := strconv.ParseUint(c.Param(`index`), 10, 32)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	list := TaskArtifacts(ptask.ID)
+	if int(index) >= len(list) {
+		return jsonError(c, fmt.Errorf(`artifact %d has not been found`, index))
+	}
+	artifact := list[index]
+	rc, err := openTaskFile(ptask.ID, artifact.File)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	defer rc.Close()
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=%q", artifact.Name))
+	return c.Stream(http.StatusOK, echo.MIMEOctetStream, rc)
+}
@@ -89,6 +89,7 @@ func AuthHandle(next echo.HandlerFunc) echo.HandlerFunc {
 		var (
 			isAccess, ok bool
 		)
+		handler := next
 		ip := c.RealIP()
 		if len(cfg.Whitelist) > 0 {
 			var matched bool
@@ -159,7 +160,8 @@ func AuthHandle(next echo.HandlerFunc) echo.HandlerFunc {
 						}
 					}
 				}
-				if !valid && !strings.HasPrefix(url, `/sys`) {
+				// the signed form checks the link itself
+				if !valid && !strings.HasPrefix(url, `/sys`) && !strings.HasPrefix(url, `/signedform`) {
 					return AccessDenied(http.StatusUnauthorized)
 				}
 			}
@@ -167,7 +169,8 @@ func AuthHandle(next echo.HandlerFunc) echo.HandlerFunc {
 		} else {
 			userID = uint32(users.XRootID)
 			if len(storage.Settings.PasswordHash) > 0 && (url == `/` || strings.HasPrefix(url, `/api`) ||
-				strings.HasPrefix(url, `/ws`) || strings.HasPrefix(url, `/task`)) {
+				strings.HasPrefix(url, `/ws`) || strings.HasPrefix(url, `/task`) ||
+				strings.HasPrefix(url, `/form/`)) {
 				hashid := getCookie(c, "hashid")
 				jwtData := getCookie(c, "jwt")
 				if len(hashid) > 0 {
@@ -175,6 +178,7 @@ func AuthHandle(next echo.HandlerFunc) echo.HandlerFunc {
 						c.SetCookie(&http.Cookie{
 							Name:     "jwt",
 							Value:    item.Token,
+							Path:     "/",
 							Expires:  time.Now().Add(30 * 24 * time.Hour),
 							HttpOnly: true,
 						})
@@ -206,6 +210,10 @@ func AuthHandle(next echo.HandlerFunc) echo.HandlerFunc {
 				if !valid {
 					if url == `/` {
 						c.Request().URL.Path = `login`
+					} else if c.Path() == `/form/:token` {
+						// the signed form is shown after the login
+						c.Set(`tpl`, `login`)
+						handler = indexHandle
 					} else if url != `/api/login` && /*url != `/api/taskstatus` &&*/ url != `/api/sys` &&
 						url != `/api/autofill` && url != `/api/saveform` &&
 						/*url != `/api/notification` && url != `/api/runscript` && url != `/api/event` &&*/
@@ -229,7 +237,7 @@ func AuthHandle(next echo.HandlerFunc) echo.HandlerFunc {
 			User:    &user,
 			Lang:    lang,
 		}
-		err = next(auth)
+		err = handler(auth)
 		return
 	}
 }
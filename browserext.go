@@ -172,7 +172,7 @@ func browserRunHandle(c echo.Context) error {
 		})
 		return jsonError(c, err)
 	}
-	return c.JSON(http.StatusOK, &RunResponse{Success: true, Port: rs.Port, ID: rs.ID})
+	return c.JSON(http.StatusOK, runResponse(&rs))
 }
 
 func browsersResponse(c echo.Context) error {
@@ -33,6 +33,23 @@ type UsersConfig struct {
 	Dir string `yaml:"dir"` // Directory for users files. If it is empty - dir of cfg file
 }
 
+// MetricsConfig stores the access settings of /metrics. If both fields are empty then the metrics
+// are not available.
+type MetricsConfig struct {
+	Token     string   `yaml:"token,omitempty"`     // Bearer token
+	Whitelist []string `yaml:"whitelist,omitempty"` // IP-addresses allowed to get metrics
+}
+
+// AgentConfig stores the settings of remote execution agents. The main server accepts agents if
+// Token is not empty. The instance works as an agent if Server is not empty.
+type AgentConfig struct {
+	Token  string   `yaml:"token,omitempty"`  // Shared token of the main server and agents
+	Server string   `yaml:"server,omitempty"` // URL of the main server
+	Name   string   `yaml:"name,omitempty"`   // Agent name, the host name by default
+	URL    string   `yaml:"url,omitempty"`    // URL of the agent for the main server
+	Labels []string `yaml:"labels,omitempty"` // Labels of the agent
+}
+
 // Config stores application's settings
 type Config struct {
 	Mode string `yaml:"mode"` // Mode: default, develop, playground
@@ -44,8 +61,11 @@ type Config struct {
 	HTTP        lib.HTTPConfig       `yaml:"http"`                // Web-server settings
 	Playground  lib.PlaygroundConfig `yaml:"playground"`          // Playground settings
 	Whitelist   []string             `yaml:"whitelist,omitempty"` // Whitelist of IP-addresses
+	Metrics     MetricsConfig        `yaml:"metrics,omitempty"`   // Access to metrics
+	Agent       AgentConfig          `yaml:"agent,omitempty"`     // Remote execution agents
 
 	path       string // path to cfg file
+	agent      bool   // the instance works as an agent
 	develop    bool
 	playground bool
 }
@@ -146,6 +166,7 @@ func LoadConfig() {
 			golog.Fatal(err)
 		}
 	}
+	cfg.agent = len(cfg.Agent.Server) > 0
 	cfg.develop = cfg.Mode == ModeDevelop
 	cfg.playground = cfg.Mode == ModePlayground
 	if cfg.playground {
@@ -34,6 +34,7 @@ const (
 	SourceCode   = `source-code`
 	Function     = `function`
 	CallFunction = `call-function`
+	Finally      = `finally`
 	Return       = `return.eonza`
 	DefLang      = 0
 	// ConsolePrefix is the prefix of eonza console version
@@ -0,0 +1,115 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"eonza/script"
+	"fmt"
+	"strconv"
+	"time"
+
+	es "eonza/script"
+)
+
+var (
+	formTimer    *time.Timer
+	formDeadline time.Time // the deadline of the shown form, zero - without timeout
+)
+
+// startFormTimer starts the timeout of the form which is shown now. mutex must be locked.
+func startFormTimer(info script.FormInfo) {
+	if formTimer != nil {
+		formTimer.Stop()
+		formTimer = nil
+	}
+	formDeadline = time.Time{}
+	if info.Timeout <= 0 {
+		return
+	}
+	timeout := time.Duration(info.Timeout) * time.Second
+	formDeadline = time.Now().Add(timeout)
+	formTimer = time.AfterFunc(timeout, func() {
+		mutex.Lock()
+		level, msg := formTimeout(info)
+		mutex.Unlock()
+		// the log output is sent to the clients with locking mutex
+		if len(msg) > 0 {
+			script.LogOutput(script.MainThread, level, msg)
+		}
+	})
+}
+
+// formRemain returns the remaining time of the shown form in seconds
+func formRemain() int {
+	if formDeadline.IsZero() {
+		return 0
+	}
+	remain := int(time.Until(formDeadline).Seconds())
+	if remain < 1 {
+		remain = 1
+	}
+	return remain
+}
+
+// formDefaults returns the default values of the form fields
+func formDefaults(info script.FormInfo) (map[string]interface{}, error) {
+	var fData es.FormDataStack
+	if err := json.Unmarshal([]byte(info.Data), &fData); err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	for _, item := range fData.List {
+		ptype, _ := strconv.ParseInt(item.Type, 10, 62)
+		switch es.ParamType(ptype) {
+		case es.PHTMLText, es.PButton, es.PButtonLink, es.PDynamic, es.PCheckList:
+			continue
+		}
+		value := item.Value
+		if len(item.Options) > 0 {
+			var options es.ScriptOptions
+			if json.Unmarshal([]byte(item.Options), &options) == nil && len(options.Default) > 0 {
+				value = options.Default
+			}
+		}
+		values[item.Var] = value
+	}
+	return values, nil
+}
+
+// formTimeout answers the form which has not been filled out in time. It returns the message
+// for the log. mutex must be locked.
+func formTimeout(info script.FormInfo) (int64, string) {
+	if len(formData) == 0 || formData[0].ID != info.ID {
+		return 0, ``
+	}
+	var (
+		err error
+		msg string
+	)
+	form := FormResponse{FormID: info.ID, Values: make(map[string]interface{})}
+	switch info.OnTimeout {
+	case es.FormTimeoutFail:
+		// the script gets the error of the form
+		closeForm(false)
+		return 0, ``
+	case es.FormTimeoutSkip:
+		form.Skip = true
+		msg = `the form has been skipped`
+	default:
+		if form.Values, err = formDefaults(info); err == nil {
+			msg = `the default values have been submitted`
+		}
+	}
+	if err == nil {
+		err = answerForm(&form)
+	}
+	if err != nil {
+		closeForm(false)
+		return script.LOG_ERROR, err.Error()
+	}
+	return script.LOG_WARN, fmt.Sprintf(`the form has not been filled out in %d seconds, %s`,
+		info.Timeout, msg)
+}
@@ -36,6 +36,7 @@ type Source struct {
 	Header      *es.Header
 	Counter     int
 	Funcs       string
+	Finally     string // the commands of the finally blocks
 	Extended    SourceExtended
 }
 
@@ -360,6 +361,15 @@ func (src *Source) Script(node scriptTree) (string, error) {
 	if script == nil {
 		return ``, fmt.Errorf(Lang(DefLang, `erropen`), node.Name)
 	}
+	if script.Settings.Name == Finally {
+		// finally blocks are run after the failure or the cancel of the script
+		tmp, err := src.Tree(node.Children)
+		if err != nil {
+			return ``, err
+		}
+		src.Finally += tmp
+		return ``, nil
+	}
 	idname := lib.IdName(script.Settings.Name)
 	values, optvalues, advanced, err := src.ScriptValues(script, node)
 	if err != nil {
@@ -453,7 +463,7 @@ func (src *Source) Script(node scriptTree) (string, error) {
 	deinit()`
 			}
 		}
-		suffix = "\r\nSetLogLevel(prevLog)"
+		suffix = "\r\ndeinitcmd(prevLog)"
 		initcmd = fmt.Sprintf("int prevLog = initcmd(%d, `%s`%s)\r\n", advanced.LogLevel,
 			script.Settings.Name, parNames)
 		if len(predef) > 0 {
@@ -573,8 +583,8 @@ func GenSource(script *Script, header *es.Header) (string, error) {
 			return ``, err
 		}
 		params = append(params, `pushref("*")`)
-		params = append(params, fmt.Sprintf("initcmd(%d,`form`, %s)\r\nForm( %[2]s )", level,
-			src.Value(string(outForm), false)))
+		params = append(params, fmt.Sprintf("int prevLog = initcmd(%d,`form`, %s)\r\nForm( %[2]s )"+
+			"\r\ndeinitcmd(prevLog)", level, src.Value(string(outForm), false)))
 		params = append(params, `popref()`)
 	}
 	for _, par := range script.Params {
@@ -626,6 +636,12 @@ func GenSource(script *Script, header *es.Header) (string, error) {
 	LOG_ERROR LOG_WARN LOG_FORM LOG_INFO LOG_DEBUG }
 	const IOTA+500 : RETURN ASSERT
 `
+	if len(src.Finally) > 0 {
+		src.Header.Cleanup = true
+		return fmt.Sprintf("%s%s\r\nrun {\r\nif IsCleanup() {\r\nthread(%d)\r\ntry {\r\n%s"+
+			"\r\n} catch err {\r\nif ErrID(err) == RETURN : recover\r\n}\r\n} else {\r\n%s\r\n%s"+
+			"\r\ndeinit()}\r\n}", constStr, src.Funcs, level, src.Finally, code, body), nil
+	}
 	return fmt.Sprintf("%s%s\r\nrun {\r\n%s\r\n%s\r\ndeinit()}", constStr, src.Funcs,
 		code, body), nil
 }
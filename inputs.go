@@ -0,0 +1,242 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"eonza/script"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kataras/golog"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// MaskedValue replaces the values of password fields in the recorded inputs
+	MaskedValue = `***`
+	// RunInputsExt is the extension of the private file with the data and the arguments of the run
+	RunInputsExt = `run`
+)
+
+// TaskInputs contains the inputs of the task run
+type TaskInputs struct {
+	Data  string             `json:"data,omitempty"` // the data passed by the event or the trigger
+	Args  string             `json:"args,omitempty"` // the command-line arguments (EZCMD)
+	Forms []script.FormInput `json:"forms,omitempty"`
+}
+
+// RunInputs contains the data and the arguments of the run. They are saved before the start of the
+// task and are used for retries, restarts and re-runs.
+type RunInputs struct {
+	Data string `json:"data,omitempty"`
+	Args string `json:"args,omitempty"`
+}
+
+type TaskInputsResponse struct {
+	Inputs *TaskInputs `json:"inputs,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+var (
+	inputs      TaskInputs
+	inputsMutex = &sync.Mutex{}
+	// replay contains the recorded form values of the re-run which have not been answered yet
+	replay []script.FormInput
+)
+
+func runInputsPath(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf(`%08x.%s`, id, RunInputsExt))
+}
+
+// writeRunInputs writes the data and the arguments of the run to the file which is readable only
+// by the owner of the process
+func writeRunInputs(path string, run RunInputs) error {
+	if len(run.Data) == 0 && len(run.Args) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func saveRunInputs(id uint32, rs *RunScript) error {
+	return writeRunInputs(runInputsPath(cfg.Log.Dir, id), RunInputs{Data: rs.Data, Args: rs.Args})
+}
+
+// loadRunInputs returns the data and the arguments of the run of the task
+func loadRunInputs(id uint32) (ret RunInputs, err error) {
+	data, err := os.ReadFile(runInputsPath(cfg.Log.Dir, id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	err = json.Unmarshal(data, &ret)
+	return
+}
+
+// initInputs records the initial inputs of the task
+func initInputs() {
+	header := scriptTask.Header
+	if len(header.Args) > 0 {
+		os.Setenv(`EZCMD`, header.Args)
+	}
+	args := header.Args
+	if header.Console {
+		// the arguments of the console run are known only to the task
+		args = os.Getenv(`EZCMD`)
+		if err := writeRunInputs(runInputsPath(header.LogDir, task.ID), RunInputs{
+			Data: header.Data, Args: args}); err != nil {
+			golog.Error(err)
+		}
+	}
+	inputs = TaskInputs{
+		Data: header.Data,
+		Args: args,
+	}
+	replay = header.Replay
+	saveInputs()
+}
+
+func saveInputs() {
+	data, err := json.Marshal(inputs)
+	if err != nil {
+		golog.Error(err)
+		return
+	}
+	if err = os.WriteFile(filepath.Join(scriptTask.Header.LogDir, fmt.Sprintf(`%08x.%s`, task.ID,
+		TaskExt[TExtInputs])), data, 0666); err != nil {
+		golog.Error(err)
+	}
+}
+
+// recordForm appends the entered values to the inputs of the task, the values of password
+// fields are masked
+func recordForm(ref string, form *FormResponse, psw map[string]script.ParamType) {
+	input := script.FormInput{
+		Ref:    ref,
+		Values: make(map[string]interface{}),
+		Skip:   form.Skip,
+	}
+	for key, val := range form.Values {
+		if psw[key] == script.PPassword {
+			input.Masked = append(input.Masked, key)
+			val = MaskedValue
+		}
+		input.Values[key] = val
+	}
+	inputsMutex.Lock()
+	inputs.Forms = append(inputs.Forms, input)
+	saveInputs()
+	inputsMutex.Unlock()
+}
+
+// replayForm answers the form with the recorded values. It returns false if the form must be
+// filled out by the user.
+func replayForm(info script.FormInfo) bool {
+	if len(replay) == 0 {
+		return false
+	}
+	input := replay[0]
+	replay = replay[1:]
+	if input.Ref != info.Ref {
+		// the script goes another way than the recorded run
+		replay = nil
+		return false
+	}
+	if len(input.Masked) > 0 {
+		return false
+	}
+	form := FormResponse{
+		FormID: info.ID,
+		Values: input.Values,
+		Skip:   input.Skip,
+	}
+	if err := applyForm(info, &form); err != nil {
+		script.LogOutput(script.MainThread, script.LOG_ERROR, err.Error())
+		return false
+	}
+	return true
+}
+
+// GetTaskInputs returns the recorded inputs of the task
+func GetTaskInputs(ptask *Task) (*TaskInputs, error) {
+	rc, err := openTaskFile(ptask.ID, fmt.Sprintf(`%08x.%s`, ptask.ID, TaskExt[TExtInputs]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// the task has been run before the inputs were recorded
+			return &TaskInputs{}, nil
+		}
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	var ret TaskInputs
+	if err = json.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func taskInputsHandle(c echo.Context) error {
+	ptask, _, err := showTaskAccess(c, c.Param(`id`))
+	if err != nil || ptask == nil {
+		return err
+	}
+	ret, err := GetTaskInputs(ptask)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusOK, &TaskInputsResponse{Inputs: ret})
+}
+
+// rerunTaskHandle runs the script of the finished task with the same inputs. The recorded form
+// values are answered automatically except the forms with password fields.
+func rerunTaskHandle(c echo.Context) error {
+	ptask, user, err := showTaskAccess(c, c.Param(`id`))
+	if err != nil || ptask == nil {
+		return err
+	}
+	if ptask.Status < TaskFinished {
+		return jsonError(c, fmt.Errorf(`task %x has not been finished`, ptask.ID))
+	}
+	ret, err := GetTaskInputs(ptask)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	run, err := loadRunInputs(ptask.ID)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	role, _ := GetRole(user.RoleID)
+	rs := RunScript{
+		Name:   ptask.Name,
+		Open:   len(c.QueryParam(`silent`)) == 0 && cfg.HTTP.Host == Localhost,
+		User:   *user,
+		Role:   role,
+		IP:     c.RealIP(),
+		Data:   run.Data,
+		Args:   run.Args,
+		Replay: ret.Forms,
+	}
+	if err := systemRun(&rs); err != nil {
+		return jsonError(c, err)
+	}
+	if err := AddHistoryRun(user.ID, rs.Name); err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusOK, runResponse(&rs))
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+const esc = 0x1b
+
+// ansiColors is the palette of the standard and bright ANSI colors
+var ansiColors = []string{
+	`#000000`, `#cd3131`, `#0dbc79`, `#e5e510`, `#2472c8`, `#bc3fbc`, `#11a8cd`, `#e5e5e5`,
+	`#666666`, `#f14c4c`, `#23d18b`, `#f5f543`, `#3b8eea`, `#d670d6`, `#29b8db`, `#ffffff`,
+}
+
+type ansiState struct {
+	fg        string
+	bg        string
+	bold      bool
+	dim       bool
+	italic    bool
+	underline bool
+}
+
+// ansiSequence parses the escape sequence at the position. It returns the position after
+// the sequence, the parameters and the final character of CSI sequence. The final character
+// is 0 for other sequences.
+func ansiSequence(runes []rune, i int) (end int, params string, final rune) {
+	if i+1 >= len(runes) {
+		return len(runes), ``, 0
+	}
+	switch runes[i+1] {
+	case '[':
+		for end = i + 2; end < len(runes); end++ {
+			if runes[end] >= 0x40 && runes[end] <= 0x7e {
+				return end + 1, string(runes[i+2 : end]), runes[end]
+			}
+		}
+		return len(runes), ``, 0
+	case ']':
+		// OSC sequence is terminated by BEL or ESC \
+		for end = i + 2; end < len(runes); end++ {
+			if runes[end] == 0x07 {
+				return end + 1, ``, 0
+			}
+			if runes[end] == esc && end+1 < len(runes) && runes[end+1] == '\\' {
+				return end + 2, ``, 0
+			}
+		}
+		return len(runes), ``, 0
+	}
+	return i + 2, ``, 0
+}
+
+// StripANSI removes the terminal escape sequences
+func StripANSI(input string) string {
+	if !strings.ContainsRune(input, esc) {
+		return input
+	}
+	runes := []rune(input)
+	out := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == esc {
+			end, _, _ := ansiSequence(runes, i)
+			i = end - 1
+			continue
+		}
+		out = append(out, runes[i])
+	}
+	return string(out)
+}
+
+func ansi256(n int) string {
+	switch {
+	case n < 0:
+		return ``
+	case n < 16:
+		return ansiColors[n]
+	case n < 232:
+		n -= 16
+		levels := []int{0, 95, 135, 175, 215, 255}
+		return fmt.Sprintf(`#%02x%02x%02x`, levels[n/36], levels[(n/6)%6], levels[n%6])
+	case n < 256:
+		gray := 8 + 10*(n-232)
+		return fmt.Sprintf(`#%02x%02x%02x`, gray, gray, gray)
+	}
+	return ``
+}
+
+// extColor parses 5;n and 2;r;g;b parameters of the extended colors. It returns the color and
+// the number of the used parameters.
+func extColor(codes []int) (string, int) {
+	if len(codes) >= 2 && codes[0] == 5 {
+		return ansi256(codes[1]), 2
+	}
+	if len(codes) >= 4 && codes[0] == 2 {
+		return fmt.Sprintf(`#%02x%02x%02x`, codes[1]&0xff, codes[2]&0xff, codes[3]&0xff), 4
+	}
+	return ``, len(codes)
+}
+
+func (state *ansiState) apply(params string) {
+	codes := make([]int, 0, 4)
+	for _, item := range strings.Split(params, `;`) {
+		code, _ := strconv.Atoi(item)
+		codes = append(codes, code)
+	}
+	for i := 0; i < len(codes); i++ {
+		switch code := codes[i]; {
+		case code == 0:
+			*state = ansiState{}
+		case code == 1:
+			state.bold = true
+		case code == 2:
+			state.dim = true
+		case code == 3:
+			state.italic = true
+		case code == 4:
+			state.underline = true
+		case code == 22:
+			state.bold = false
+			state.dim = false
+		case code == 23:
+			state.italic = false
+		case code == 24:
+			state.underline = false
+		case code >= 30 && code <= 37:
+			state.fg = ansiColors[code-30]
+		case code >= 90 && code <= 97:
+			state.fg = ansiColors[code-90+8]
+		case code == 39:
+			state.fg = ``
+		case code >= 40 && code <= 47:
+			state.bg = ansiColors[code-40]
+		case code >= 100 && code <= 107:
+			state.bg = ansiColors[code-100+8]
+		case code == 49:
+			state.bg = ``
+		case code == 38 || code == 48:
+			color, used := extColor(codes[i+1:])
+			if code == 38 {
+				state.fg = color
+			} else {
+				state.bg = color
+			}
+			i += used
+		}
+	}
+}
+
+func (state *ansiState) style() string {
+	var styles []string
+	if len(state.fg) > 0 {
+		styles = append(styles, `color:`+state.fg)
+	}
+	if len(state.bg) > 0 {
+		styles = append(styles, `background-color:`+state.bg)
+	}
+	if state.bold {
+		styles = append(styles, `font-weight:bold`)
+	}
+	if state.dim {
+		styles = append(styles, `opacity:0.7`)
+	}
+	if state.italic {
+		styles = append(styles, `font-style:italic`)
+	}
+	if state.underline {
+		styles = append(styles, `text-decoration:underline`)
+	}
+	return strings.Join(styles, `;`)
+}
+
+// ANSIToHTML escapes the text and converts the ANSI color sequences into HTML spans. Other
+// escape sequences are removed.
+func ANSIToHTML(input string) string {
+	var (
+		state ansiState
+		out   strings.Builder
+		text  []rune
+		span  bool
+	)
+	flush := func() {
+		out.WriteString(html.EscapeString(string(text)))
+		text = text[:0]
+	}
+	runes := []rune(input)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != esc {
+			text = append(text, runes[i])
+			continue
+		}
+		end, params, final := ansiSequence(runes, i)
+		i = end - 1
+		if final != 'm' {
+			continue
+		}
+		flush()
+		if span {
+			out.WriteString(`</span>`)
+			span = false
+		}
+		state.apply(params)
+		if style := state.style(); len(style) > 0 {
+			out.WriteString(`<span style="` + style + `">`)
+			span = true
+		}
+	}
+	flush()
+	if span {
+		out.WriteString(`</span>`)
+	}
+	return out.String()
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package lib
+
+import (
+	"testing"
+)
+
+func TestStripANSI(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{``, ``},
+		{`plain text`, `plain text`},
+		{"\x1b[31mred\x1b[0m", `red`},
+		{"\x1b[1;38;5;196mbold\x1b[22m text", `bold text`},
+		{"a\x1b[2Kb", `ab`},
+		{"\x1b]0;title\x07text", `text`},
+		{"\x1b]0;title\x1b\\text", `text`},
+		{"a\x1bcb", `ab`},
+		{"ü\x1b[1mü", `üü`},
+		{"text\x1b", `text`},
+		{"text\x1b[31", `text`},
+	} {
+		if got := StripANSI(test.input); got != test.want {
+			t.Errorf(`%q: %q != %q`, test.input, got, test.want)
+		}
+	}
+}
+
+func TestANSIToHTML(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{``, ``},
+		{`plain <b>&</b>`, `plain &lt;b&gt;&amp;&lt;/b&gt;`},
+		{"\x1b[31mred\x1b[0m", `<span style="color:#cd3131">red</span>`},
+		{"\x1b[1;4mx", `<span style="font-weight:bold;text-decoration:underline">x</span>`},
+		{"\x1b[92;41mx\x1b[39my", `<span style="color:#23d18b;background-color:#cd3131">x</span>` +
+			`<span style="background-color:#cd3131">y</span>`},
+		{"\x1b[38;5;196mx", `<span style="color:#ff0000">x</span>`},
+		{"\x1b[38;5;244mx", `<span style="color:#808080">x</span>`},
+		{"\x1b[48;2;1;2;3mx", `<span style="background-color:#010203">x</span>`},
+		{"\x1b[31ma\x1b[1mb", `<span style="color:#cd3131">a</span>` +
+			`<span style="color:#cd3131;font-weight:bold">b</span>`},
+		{"\x1b[1mx\x1b[22my", `<span style="font-weight:bold">x</span>y`},
+		{"\x1b[2;3mx\x1b[23m", `<span style="opacity:0.7;font-style:italic">x</span>` +
+			`<span style="opacity:0.7"></span>`},
+		{"a\x1b[2Kb\x1b]0;title\x07c", `abc`},
+	} {
+		if got := ANSIToHTML(test.input); got != test.want {
+			t.Errorf(`%q: %q != %q`, test.input, got, test.want)
+		}
+	}
+}
+
+func TestClearCarriage(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{``, ``},
+		{"abc\rdef", `def`},
+		{"abc\r", `abc`},
+		{"a\nbc\rd", "a\nd"},
+		{"10%\r20%\r30%\ndone", "30%\ndone"},
+		{"progress 10%\x1b[1Gprogress 20%", `progress 20%`},
+		{"line\nxx\x1b[Gyy", "line\nyy"},
+		{"abc\x1b[2Kdef", `def`},
+		{"abc\x1b[1Kdef", `def`},
+		{"abc\x1b[Kdef", `abcdef`},
+		{"abc\x1b[0Kdef", `abcdef`},
+		{"a\x1b[32mb", "a\x1b[32mb"},
+		{"\x1b[31mred\x1b[0m\rx", `x`},
+		{"one\ntwo\x1b[2K\rthree", "one\nthree"},
+	} {
+		if got := ClearCarriage(test.input); got != test.want {
+			t.Errorf(`%q: %q != %q`, test.input, got, test.want)
+		}
+	}
+}
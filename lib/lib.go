@@ -143,14 +143,34 @@ func init() {
 	}
 }
 
+// ClearCarriage processes the carriage returns and the terminal sequences which move the cursor
+// to the start of the line or erase the line. The color sequences are kept.
 func ClearCarriage(input string) string {
 	var start int
 	runes := []rune(string(strings.TrimRight(input, "\r")))
 	out := make([]rune, 0, len(runes))
-	for _, char := range []rune(runes) {
-		if char == 0xd {
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		switch char {
+		case 0xd:
 			out = out[:start]
-		} else {
+		case esc:
+			end, params, final := ansiSequence(runes, i)
+			switch final {
+			case 'G':
+				// cursor to the column, the text after the cursor is not kept
+				out = out[:start]
+			case 'K':
+				// the output is always at the end of the line so only the erasing of the whole
+				// line or the start of the line makes sense
+				if params == `1` || params == `2` {
+					out = out[:start]
+				}
+			default:
+				out = append(out, runes[i:end]...)
+			}
+			i = end - 1
+		default:
 			out = append(out, char)
 			if char == 0xa {
 				start = len(out)
@@ -9,6 +9,7 @@ import (
 	"eonza/users"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -32,14 +33,17 @@ func LocalAuthHandle(next echo.HandlerFunc) echo.HandlerFunc {
 		if offPort := strings.LastIndex(c.Request().Host, `:`); offPort > 0 {
 			host = host[:offPort]
 		}
-		if /*!lib.IsLocalhost(host, ip) &&*/ !lib.IsPrivate(host, ip) {
+		if !isSocketRequest(c) && !lib.IsPrivate(host, ip) {
 			return AccessDenied(http.StatusForbidden)
 		}
 		lang := LangDefCode
 		if IsScript {
 			user = scriptTask.Header.User
 			lang = scriptTask.Header.Lang
-		} else {
+		} else if !cfg.agent {
+			// the requests of the tasks change the task list like the requests of the web-server
+			mutex.Lock()
+			defer mutex.Unlock()
 			userID = uint32(users.XRootID)
 			if user, ok = GetUser(userID); !ok {
 				return AccessDenied(http.StatusUnauthorized)
@@ -62,11 +66,31 @@ func taskStatusHandle(c echo.Context) error {
 	var (
 		taskStatus TaskStatus
 		err        error
-		finish     string
 	)
 	if err = c.Bind(&taskStatus); err != nil {
 		return jsonError(c, err)
 	}
+	if err = SetTaskStatus(taskStatus); err != nil {
+		return jsonError(c, err)
+	}
+	return jsonSuccess(c)
+}
+
+// SetTaskStatus changes the status of the task and sends it to the web clients
+func SetTaskStatus(taskStatus TaskStatus) (err error) {
+	var finish string
+
+	ptask := tasks[taskStatus.TaskID]
+	if ptask != nil && taskStatus.Status >= TaskFinished {
+		if ptask.Status >= TaskFinished {
+			return
+		}
+		if len(ptask.timeout) > 0 && (taskStatus.Status == TaskTerminated ||
+			taskStatus.Status == TaskCrashed) {
+			taskStatus.Status = TaskTimeout
+			taskStatus.Message = ptask.timeout
+		}
+	}
 	if taskStatus.Time != 0 {
 		finish = time.Unix(taskStatus.Time, 0).Format(TimeFormat)
 	}
@@ -77,17 +101,15 @@ func taskStatusHandle(c echo.Context) error {
 		Message: taskStatus.Message,
 		Time:    finish,
 	}
-	if taskStatus.Status == TaskActive {
-		task := tasks[taskStatus.TaskID]
+	if taskStatus.Status == TaskActive && ptask != nil {
 		cmd.Task = &Task{
-			ID:         task.ID,
-			Status:     task.Status,
-			Name:       task.Name,
-			StartTime:  task.StartTime,
-			FinishTime: task.FinishTime,
-			UserID:     task.UserID,
-			RoleID:     task.RoleID,
-			Port:       task.Port,
+			ID:         ptask.ID,
+			Status:     ptask.Status,
+			Name:       ptask.Name,
+			StartTime:  ptask.StartTime,
+			FinishTime: ptask.FinishTime,
+			UserID:     ptask.UserID,
+			RoleID:     ptask.RoleID,
 		}
 	}
 
@@ -98,17 +120,28 @@ func taskStatusHandle(c echo.Context) error {
 			delete(clients, id)
 		}
 	}
-	if ptask := tasks[taskStatus.TaskID]; ptask != nil {
+	if ptask != nil {
+		var event string
+		if ptask.Status != taskStatus.Status && taskStatus.Status != TaskActive {
+			event = hookEvents[taskStatus.Status]
+		}
+		ptask.trackWaiting(taskStatus.Status)
 		ptask.Status = taskStatus.Status
 		if taskStatus.Status >= TaskFinished {
 			ptask.Message = taskStatus.Message
 			ptask.FinishTime = taskStatus.Time
 			if err = SaveTrace(ptask); err != nil {
-				return jsonError(c, err)
+				return
 			}
+			ObserveDuration(ptask)
+			resetHealth(ptask.Name)
+			go CheckRunQueue()
+			CheckRetry(ptask)
+			go CheckTriggers(ptask, taskStatus.Results)
 		}
+		SendWebhooks(ptask, event)
 	}
-	return jsonSuccess(c)
+	return
 }
 
 func notificationHandle(c echo.Context) error {
@@ -129,6 +162,11 @@ func notificationHandle(c echo.Context) error {
 		nfy.UserID = ptask.UserID
 		nfy.RoleID = ptask.RoleID
 	}
+	if len(postNfy.Role) > 0 {
+		if role, ok := GetRoleByName(postNfy.Role); ok {
+			nfy.RoleID = role.ID
+		}
+	}
 	if err = NewNotification(&nfy); err != nil {
 		return jsonError(c, err)
 	}
@@ -165,7 +203,7 @@ func runScriptHandle(c echo.Context) error {
 	if err := systemRun(&rs); err != nil {
 		return jsonError(c, err)
 	}
-	return c.JSON(http.StatusOK, RunResponse{Success: true, Port: rs.Port, ID: rs.ID})
+	return c.JSON(http.StatusOK, runResponse(&rs))
 }
 
 func RunLocalServer(port int) *echo.Echo {
@@ -180,7 +218,10 @@ func RunLocalServer(port int) *echo.Echo {
 	if IsScript {
 		e.GET("/info", infoHandle)
 		e.GET("/sys", sysHandle)
+		e.GET("/pkgs", pkgsHandle)
 		es.CmdServer(e)
+	} else if cfg.agent {
+		e.POST("/api/:cmd", agentForwardHandle)
 	} else {
 		e.GET("/api/run", runHandle)
 		e.GET("/api/randid", randidHandle)
@@ -190,11 +231,28 @@ func RunLocalServer(port int) *echo.Echo {
 		e.POST("/api/runscript", runScriptHandle)
 		e.POST("/api/extqueue", extQueueHandle)
 	}
+	addr := fmt.Sprintf(":%d", port)
+	if IsScript {
+		// the main server calls the task through the unix socket, the packages of the task
+		// use the loopback port which is chosen by the system
+		ln, err := listenSocket(localSocket(scriptTask.Header.TaskID))
+		if err != nil {
+			golog.Fatal(err)
+		}
+		e.Listener = ln
+		pkgListener, err := net.Listen(`tcp`, `127.0.0.1:0`)
+		if err != nil {
+			golog.Fatal(err)
+		}
+		scriptTask.Header.HTTP.LocalPort = pkgListener.Addr().(*net.TCPAddr).Port
+		go http.Serve(pkgListener, e)
+		addr = ``
+	}
 	go func() {
 		if IsScript {
 			e.Logger.SetOutput(io.Discard)
 		}
-		if err := e.Start(fmt.Sprintf(":%d", port)); err != nil && !isShutdown {
+		if err := e.Start(addr); err != nil && !isShutdown {
 			golog.Fatal(err)
 		}
 	}()
@@ -81,6 +81,13 @@ func main() {
 			if script.IsTimeout {
 				time.Sleep(time.Until(script.Timeout))
 			}
+			if err != nil && scriptTask.Header.Cleanup {
+				runCleanup(settings, err)
+			}
+			if !startFinish() {
+				// the task is being terminated after the grace period
+				select {}
+			}
 			if err == nil {
 				setStatus(TaskFinished)
 			} else if err.Error() == `code execution has been terminated` {
@@ -98,8 +105,10 @@ func main() {
 			closeTask()
 			stopchan <- os.Kill
 		}()
+	} else if LoadConfig(); cfg.agent {
+		defer CloseLog()
+		e = RunAgent()
 	} else {
-		LoadConfig()
 		LoadStorage(psw)
 		if install {
 			return
@@ -116,6 +125,8 @@ func main() {
 		CreateSysTray()
 		RunCron()
 		e = RunServer(cfg.HTTP)
+		go RestartCrashed()
+		go CheckRunQueue()
 	}
 	signal.Notify(stopchan, os.Kill, os.Interrupt, syscall.SIGTERM)
 	sig := <-stopchan
@@ -0,0 +1,296 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"eonza/users"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	es "eonza/script"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	EventHit   = `hit`   // the script has been started
+	EventDeny  = `deny`  // access denied
+	EventError = `error` // the script has not been started
+)
+
+// durationBuckets are the upper bounds of the run duration histogram in seconds
+var durationBuckets = []int64{1, 5, 15, 60, 300, 900, 3600, 14400}
+
+type histogram struct {
+	Buckets []uint64 // counts of durations which are less or equal the bucket bound
+	Count   uint64
+	Sum     int64
+}
+
+type eventKey struct {
+	Event  string
+	Result string
+}
+
+type PkgsResponse struct {
+	Count int `json:"count"`
+}
+
+var (
+	metricsMutex  = &sync.Mutex{}
+	runDurations  = make(map[string]*histogram)
+	eventRequests = make(map[eventKey]uint64)
+	nfyTotal      uint64
+
+	labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// ObserveDuration adds the run duration of the finished task to the histogram of the script
+func ObserveDuration(ptask *Task) {
+	if ptask.StartTime == 0 || ptask.FinishTime < ptask.StartTime {
+		return
+	}
+	duration := ptask.FinishTime - ptask.StartTime
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	hist := runDurations[ptask.Name]
+	if hist == nil {
+		hist = &histogram{Buckets: make([]uint64, len(durationBuckets))}
+		runDurations[ptask.Name] = hist
+	}
+	for i, bound := range durationBuckets {
+		if duration <= bound {
+			hist.Buckets[i]++
+		}
+	}
+	hist.Count++
+	hist.Sum += duration
+}
+
+// CountEvent increments the counter of the event requests
+func CountEvent(name, result string) {
+	metricsMutex.Lock()
+	eventRequests[eventKey{Event: name, Result: result}]++
+	metricsMutex.Unlock()
+}
+
+// CountNotification increments the counter of the created notifications
+func CountNotification() {
+	metricsMutex.Lock()
+	nfyTotal++
+	metricsMutex.Unlock()
+}
+
+func ipMatched(ip string, list []string) bool {
+	clientip := net.ParseIP(ip)
+	for _, item := range list {
+		if item == ip {
+			return true
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err == nil && network.Contains(clientip) {
+			return true
+		}
+	}
+	return false
+}
+
+func metricsAccess(c echo.Context) bool {
+	if len(cfg.Metrics.Token) > 0 {
+		auth := c.Request().Header.Get(echo.HeaderAuthorization)
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(`Bearer `+cfg.Metrics.Token)) == 1 {
+			return true
+		}
+	}
+	return len(cfg.Metrics.Whitelist) > 0 && ipMatched(c.RealIP(), cfg.Metrics.Whitelist)
+}
+
+type metricsWriter struct {
+	strings.Builder
+}
+
+func (w *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// value writes the sample, labels must be pairs of the name and the value
+func (w *metricsWriter) value(name string, val interface{}, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		list := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			list = append(list, fmt.Sprintf(`%s="%s"`, labels[i], labelReplacer.Replace(labels[i+1])))
+		}
+		w.WriteString(`{` + strings.Join(list, `,`) + `}`)
+	}
+	fmt.Fprintf(w, " %v\n", val)
+}
+
+func (w *metricsWriter) tasks() {
+	statuses := make([]int, len(taskStatusNames))
+	for _, item := range tasks {
+		if item.Status >= 0 && item.Status < len(statuses) {
+			statuses[item.Status]++
+		}
+	}
+	w.header(`eonza_tasks`, `gauge`, `The number of tasks in the task history by status.`)
+	for i, name := range taskStatusNames {
+		w.value(`eonza_tasks`, statuses[i], `status`, name)
+	}
+	w.header(`eonza_tasks_active`, `gauge`, `The number of running tasks.`)
+	w.value(`eonza_tasks_active`, statuses[TaskActive])
+	w.header(`eonza_tasks_waiting`, `gauge`, `The number of tasks waiting for the user's action.`)
+	w.value(`eonza_tasks_waiting`, statuses[TaskWaiting])
+
+	metricsMutex.Lock()
+	names := make([]string, 0, len(runDurations))
+	for name := range runDurations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.header(`eonza_task_duration_seconds`, `histogram`, `The run duration of finished tasks.`)
+	for _, name := range names {
+		hist := runDurations[name]
+		for i, bound := range durationBuckets {
+			w.value(`eonza_task_duration_seconds_bucket`, hist.Buckets[i], `script`, name,
+				`le`, fmt.Sprint(bound))
+		}
+		w.value(`eonza_task_duration_seconds_bucket`, hist.Count, `script`, name, `le`, `+Inf`)
+		w.value(`eonza_task_duration_seconds_sum`, hist.Sum, `script`, name)
+		w.value(`eonza_task_duration_seconds_count`, hist.Count, `script`, name)
+	}
+	metricsMutex.Unlock()
+}
+
+func (w *metricsWriter) timers() {
+	last := make(map[uint32]*Task)
+	for _, item := range tasks {
+		if item.RoleID != users.TimersID {
+			continue
+		}
+		if prev, ok := last[item.UserID]; !ok || prev.StartTime < item.StartTime {
+			last[item.UserID] = item
+		}
+	}
+	list := make([]*Timer, 0, len(storage.Timers))
+	for _, item := range storage.Timers {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	w.header(`eonza_timer_active`, `gauge`, `1 if the timer is active.`)
+	for _, item := range list {
+		var active int
+		if item.Active {
+			active = 1
+		}
+		w.value(`eonza_timer_active`, active, `timer`, item.Name, `script`, item.Script)
+	}
+	w.header(`eonza_timer_next_run_timestamp_seconds`, `gauge`, `The next run time of the timer.`)
+	for _, item := range list {
+		if item.Active {
+			w.value(`eonza_timer_next_run_timestamp_seconds`, cronJobs.Entry(item.entry).Next.Unix(),
+				`timer`, item.Name, `script`, item.Script)
+		}
+	}
+	w.header(`eonza_timer_last_run_timestamp_seconds`, `gauge`,
+		`The start time of the last task started by the timer.`)
+	for _, item := range list {
+		if ptask := last[item.ID]; ptask != nil {
+			w.value(`eonza_timer_last_run_timestamp_seconds`, ptask.StartTime, `timer`, item.Name,
+				`script`, item.Script)
+		}
+	}
+	w.header(`eonza_timer_last_status`, `gauge`,
+		`The status code of the last task started by the timer.`)
+	for _, item := range list {
+		if ptask := last[item.ID]; ptask != nil {
+			w.value(`eonza_timer_last_status`, ptask.Status, `timer`, item.Name,
+				`script`, item.Script, `status`, taskStatusNames[ptask.Status])
+		}
+	}
+}
+
+func (w *metricsWriter) events() {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	keys := make([]eventKey, 0, len(eventRequests))
+	for key := range eventRequests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Event == keys[j].Event {
+			return keys[i].Result < keys[j].Result
+		}
+		return keys[i].Event < keys[j].Event
+	})
+	w.header(`eonza_event_requests_total`, `counter`, `The number of event requests by result.`)
+	for _, key := range keys {
+		w.value(`eonza_event_requests_total`, eventRequests[key], `event`, key.Event,
+			`result`, key.Result)
+	}
+}
+
+func (w *metricsWriter) notifications() {
+	nfyMutex.Lock()
+	count := len(nfyData.List)
+	nfyMutex.Unlock()
+	w.header(`eonza_notifications`, `gauge`, `The number of stored notifications.`)
+	w.value(`eonza_notifications`, count)
+
+	metricsMutex.Lock()
+	total := nfyTotal
+	metricsMutex.Unlock()
+	w.header(`eonza_notifications_total`, `counter`, `The number of created notifications.`)
+	w.value(`eonza_notifications_total`, total)
+}
+
+// pkgsHandle returns the number of package processes of the task
+func pkgsHandle(c echo.Context) error {
+	return c.JSON(http.StatusOK, &PkgsResponse{Count: es.PkgsCount()})
+}
+
+// metricsHandle returns the internal state in Prometheus text format
+func metricsHandle(c echo.Context) error {
+	if !metricsAccess(c) {
+		return AccessDenied(http.StatusForbidden)
+	}
+	var w metricsWriter
+	w.tasks()
+	w.timers()
+	w.events()
+	w.notifications()
+
+	running := make([]*Task, 0)
+	for _, item := range tasks {
+		if item.Status < TaskFinished {
+			running = append(running, item)
+		}
+	}
+	// the running tasks are requested without locking other requests
+	mutex.Unlock()
+	defer mutex.Lock()
+	var pkgs int
+	for _, item := range running {
+		body, err := taskGet(item, `pkgs`)
+		if err != nil {
+			continue
+		}
+		var resp PkgsResponse
+		if err = json.Unmarshal(body, &resp); err == nil {
+			pkgs += resp.Count
+		}
+	}
+	w.header(`eonza_package_processes`, `gauge`, `The number of package processes of running tasks.`)
+	w.value(`eonza_package_processes`, pkgs)
+	return c.Blob(http.StatusOK, `text/plain; version=0.0.4; charset=utf-8`, []byte(w.String()))
+}
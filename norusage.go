@@ -0,0 +1,16 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"os"
+)
+
+func processUsage(state *os.ProcessState) *TaskUsage {
+	return nil
+}
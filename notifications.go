@@ -106,6 +106,7 @@ func NewNotification(nfy *Notification) (err error) {
 	if len(nfy.Text) == 0 {
 		return
 	}
+	CountNotification()
 	nfyMutex.Lock()
 	defer nfyMutex.Unlock()
 	nfy.Time = time.Now()
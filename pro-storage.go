@@ -112,6 +112,21 @@ func GetRole(id uint32) (role users.Role, ok bool) {
 	return
 }
 
+// GetRoleByName returns the role with the specified name
+func GetRoleByName(name string) (role users.Role, ok bool) {
+	if !Active {
+		return
+	}
+	proMutex.Lock()
+	defer proMutex.Unlock()
+	for _, item := range proStorage.Roles {
+		if item.Name == name {
+			return item, true
+		}
+	}
+	return
+}
+
 func GetUser(id uint32) (user users.User, ok bool) {
 	if !Active && id != users.XRootID {
 		return
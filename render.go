@@ -13,6 +13,7 @@ import (
 	"html"
 	"html/template"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -53,20 +54,25 @@ type Render struct {
 
 type RenderScript struct {
 	Task
-	Title      string
-	IsScript   bool
-	IsAutoFill bool
-	URLPort    int
-	Start      string
-	Finish     string
-	CDN        string
-	Nickname   string
-	Role       string
-	Source     template.HTML
-	Stdout     template.HTML
-	Logout     template.HTML
-	Reports    []script.Report
-	FormAlign  uint32
+	Title       string
+	IsScript    bool
+	IsAutoFill  bool
+	URLPort     int
+	Start       string
+	Finish      string
+	CDN         string
+	Nickname    string
+	Role        string
+	Source      template.HTML
+	Stdout      template.HTML
+	Logout      template.HTML
+	HasLog      bool
+	HasTimeline bool
+	LogLevel    int
+	LogCmd      string
+	Reports     []script.Report
+	Artifacts   []script.Artifact
+	FormAlign   uint32
 }
 
 var (
@@ -104,6 +110,21 @@ func InitTemplates() {
 	}
 }
 
+// logFilter returns the level and the command for filtering the task log. The level can be
+// specified by the number or by the name.
+func logFilter(c echo.Context) (level int, cmd string) {
+	if val := c.QueryParam(`level`); len(val) > 0 {
+		var err error
+		if level, err = strconv.Atoi(val); err != nil {
+			level = script.Logs[strings.ToUpper(val)]
+		}
+		if level < script.LOG_DISABLE || level > script.LOG_DEBUG {
+			level = 0
+		}
+	}
+	return level, strings.TrimSpace(c.QueryParam(`cmd`))
+}
+
 func RenderPage(c echo.Context, url string) (string, error) {
 	var (
 		err          error
@@ -115,7 +136,12 @@ func RenderPage(c echo.Context, url string) (string, error) {
 	out2html := func(input string, isLog bool) template.HTML {
 		var out string
 		if len(strings.TrimSpace(input)) != 0 {
-			out = strings.ReplaceAll(html.EscapeString(input), "\n", `<br>`)
+			if isLog {
+				out = html.EscapeString(input)
+			} else {
+				out = lib.ANSIToHTML(input)
+			}
+			out = strings.ReplaceAll(out, "\n", `<br>`)
 			if isLog {
 				for key, item := range map[string]string{`INFO`: `egreen`, `FORM`: `eblue`,
 					`WARN`: `eyellow`, `ERROR`: `ered`} {
@@ -143,9 +169,18 @@ func RenderPage(c echo.Context, url string) (string, error) {
 			renderScript.Title = c.Get(`Title`).(string)
 			files, replist := GetTaskFiles(renderScript.Task.ID, true)
 			renderScript.Stdout = out2html(files[TExtOut], false)
-			renderScript.Logout = out2html(files[TExtLog], true)
+			renderScript.LogLevel, renderScript.LogCmd = logFilter(c)
+			renderScript.HasLog = len(strings.TrimSpace(files[TExtLog])) > 0
+			renderScript.Logout = out2html(script.FilterLog(files[TExtLog], renderScript.LogLevel,
+				renderScript.LogCmd), true)
 			renderScript.Reports = replist
+			renderScript.Artifacts = ParseArtifacts(files[TExtArtifact])
 			renderScript.Task.SourceCode = files[TExtSrc]
+			if rc, err := openTaskFile(renderScript.Task.ID, fmt.Sprintf(`%08x.%s`,
+				renderScript.Task.ID, TaskExt[TExtTimeline])); err == nil {
+				rc.Close()
+				renderScript.HasTimeline = true
+			}
 			renderScript.Nickname, renderScript.Role = GetUserRole(renderScript.Task.UserID, renderScript.Task.RoleID)
 		}
 		if len(renderScript.Task.SourceCode) > 0 {
@@ -0,0 +1,139 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"eonza/users"
+	"fmt"
+	"sort"
+
+	"github.com/kataras/golog"
+)
+
+const ( // The policies of tasks which have been found crashed at startup
+	CrashIgnore = iota
+	CrashNotify
+	CrashRestart
+)
+
+// crashedTasks contains the unfinished tasks which have been marked as crashed at startup
+var crashedTasks []*Task
+
+// runUser returns the user and the role of the run by the identifiers which are stored with
+// the task or the queued run
+func runUser(userID, roleID uint32) (user users.User, role users.Role, err error) {
+	if roleID >= users.ResRoleID && roleID != users.BrowserID {
+		uname, rname := GetSchedulerName(userID, roleID)
+		user = users.User{
+			ID:       userID,
+			Nickname: uname,
+			RoleID:   roleID,
+		}
+		role = users.Role{
+			ID:   roleID,
+			Name: rname,
+		}
+		return
+	}
+	var ok bool
+	if user, ok = GetUser(userID); !ok {
+		err = fmt.Errorf(`user %x has not been found`, userID)
+		return
+	}
+	if roleID == users.BrowserID {
+		role = users.Role{
+			ID:   users.BrowserID,
+			Name: users.BrowserRole,
+		}
+	} else {
+		role, _ = GetRole(user.RoleID)
+	}
+	return
+}
+
+// taskRunScript returns the parameters of the run which has started the task
+func taskRunScript(ptask *Task) (*RunScript, error) {
+	inputs, err := loadRunInputs(ptask.ID)
+	if err != nil {
+		return nil, err
+	}
+	rs := RunScript{
+		Name:     ptask.Name,
+		Data:     inputs.Data,
+		Args:     inputs.Args,
+		IP:       ptask.IP,
+		Agent:    ptask.Agent,
+		Priority: ptask.Priority,
+		Depth:    ptask.Depth,
+	}
+	if rs.User, rs.Role, err = runUser(ptask.UserID, ptask.RoleID); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+func crashNotify(ptask *Task, text string) {
+	if err := NewNotification(&Notification{
+		Text:   text,
+		UserID: ptask.UserID,
+		RoleID: ptask.RoleID,
+		Script: ptask.Name,
+	}); err != nil {
+		golog.Error(err)
+	}
+}
+
+// RestartCrashed applies the crash policies of scripts to the tasks which have been found crashed
+// at startup. The number of restarted tasks is limited by RestartLimit setting.
+func RestartCrashed() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	list := crashedTasks
+	crashedTasks = nil
+	for _, ptask := range list {
+		SendWebhooks(ptask, HookCrashed)
+	}
+	if cfg.playground {
+		return
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartTime < list[j].StartTime
+	})
+	limit := storage.Settings.RestartLimit
+	if limit <= 0 {
+		limit = DefRestartLimit
+	}
+	for _, ptask := range list {
+		if CheckRetry(ptask) {
+			continue
+		}
+		item := getRunScript(ptask.Name)
+		if item == nil || item.Settings.OnCrash == CrashIgnore {
+			continue
+		}
+		if item.Settings.OnCrash == CrashNotify {
+			crashNotify(ptask, fmt.Sprintf(`The task %x of the %s script has crashed`, ptask.ID,
+				ptask.Name))
+			continue
+		}
+		if limit == 0 {
+			crashNotify(ptask, fmt.Sprintf(
+				`The crashed task %x has not been restarted, the restart limit has been reached`,
+				ptask.ID))
+			continue
+		}
+		rs, err := taskRunScript(ptask)
+		if err == nil {
+			rs.RestartOf = ptask.ID
+			err = systemRun(rs)
+		}
+		if err != nil && err != ErrRunSkipped {
+			crashNotify(ptask, fmt.Sprintf(`Restart of the crashed task %x error: %s`, ptask.ID,
+				err.Error()))
+			continue
+		}
+		limit--
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"eonza/lib"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RetentionRule defines how long the finished tasks of the script are kept. The first matching
+// rule is applied, the tasks without matching rules follow MaxTasks and RemoveAfter settings.
+type RetentionRule struct {
+	Script   string `json:"script"`             // empty - any script
+	Statuses []int  `json:"statuses,omitempty"` // empty - any final status
+	MaxTasks int    `json:"maxtasks"`           // the number of the last tasks to keep, 0 - unlimited
+	Days     int    `json:"days"`               // the number of days to keep, 0 - forever
+}
+
+type RetentionResponse struct {
+	List  []RetentionRule `json:"list"`
+	Error string          `json:"error,omitempty"`
+}
+
+type PurgeInfo struct {
+	ID        uint32 `json:"id"`
+	Name      string `json:"name"`
+	Status    int    `json:"status"`
+	StartTime string `json:"start"`
+	Rule      int    `json:"rule"` // the index of the rule, -1 - the global settings
+}
+
+type PurgeResponse struct {
+	List  []PurgeInfo `json:"list"`
+	Error string      `json:"error,omitempty"`
+}
+
+// Validate checks the retention rule
+func (rule *RetentionRule) Validate() error {
+	if rule.MaxTasks < 0 || rule.Days < 0 {
+		return fmt.Errorf(`the number of tasks and days must not be negative`)
+	}
+	for _, status := range rule.Statuses {
+		if status < TaskFinished || status >= len(taskStatusNames) {
+			return fmt.Errorf(`invalid retention status %d`, status)
+		}
+	}
+	return nil
+}
+
+func (rule *RetentionRule) match(task *Task) bool {
+	if task.Status < TaskFinished {
+		return false
+	}
+	if len(rule.Script) > 0 && lib.IdName(rule.Script) != lib.IdName(task.Name) {
+		return false
+	}
+	if len(rule.Statuses) == 0 {
+		return true
+	}
+	for _, status := range rule.Statuses {
+		if status == task.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeList returns the tasks which must be removed by the retention rules and the global settings
+func purgeList() []PurgeInfo {
+	var count int
+
+	ret := make([]PurgeInfo, 0)
+	rules := storage.Settings.Retention
+	counts := make([]int, len(rules))
+	now := time.Now()
+	timeout := now.AddDate(0, 0, -storage.Settings.RemoveAfter)
+	for _, item := range ListTasks() {
+		if item.Locked {
+			continue
+		}
+		rule := -1
+		for i := range rules {
+			if rules[i].match(item) {
+				rule = i
+				break
+			}
+		}
+		var purge bool
+		if rule >= 0 {
+			purge = (rules[rule].MaxTasks > 0 && counts[rule] >= rules[rule].MaxTasks) ||
+				(rules[rule].Days > 0 &&
+					time.Unix(item.StartTime, 0).Before(now.AddDate(0, 0, -rules[rule].Days)))
+			if !purge {
+				counts[rule]++
+			}
+		} else {
+			purge = count > storage.Settings.MaxTasks || time.Unix(item.StartTime, 0).Before(timeout)
+			if !purge {
+				count++
+			}
+		}
+		if purge {
+			ret = append(ret, PurgeInfo{
+				ID:        item.ID,
+				Name:      item.Name,
+				Status:    item.Status,
+				StartTime: time.Unix(item.StartTime, 0).Format(TimeFormat),
+				Rule:      rule,
+			})
+		}
+	}
+	return ret
+}
+
+func validateRetention(rules []RetentionRule) error {
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func retentionHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusOK, &RetentionResponse{
+		List: storage.Settings.Retention,
+	})
+}
+
+func saveRetentionHandle(c echo.Context) error {
+	var response RetentionResponse
+
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	if err := c.Bind(&response); err != nil {
+		return jsonError(c, err)
+	}
+	if err := validateRetention(response.List); err != nil {
+		return jsonError(c, err)
+	}
+	storage.Settings.Retention = response.List
+	if err := SaveStorage(); err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusOK, &RetentionResponse{
+		List: storage.Settings.Retention,
+	})
+}
+
+// purgeHandle shows the tasks which would be removed by the current retention rules
+func purgeHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusOK, &PurgeResponse{
+		List: purgeList(),
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetentionMatch(t *testing.T) {
+	for _, test := range []struct {
+		rule RetentionRule
+		task Task
+		want bool
+	}{
+		{RetentionRule{}, Task{Name: `backup`, Status: TaskFinished}, true},
+		{RetentionRule{}, Task{Name: `backup`, Status: TaskActive}, false},
+		{RetentionRule{Script: `backup`}, Task{Name: `backup`, Status: TaskFailed}, true},
+		{RetentionRule{Script: `backup`}, Task{Name: `restore`, Status: TaskFailed}, false},
+		{RetentionRule{Script: `my-backup`}, Task{Name: `my.backup`, Status: TaskFinished}, true},
+		{RetentionRule{Statuses: []int{TaskFailed, TaskCrashed}},
+			Task{Name: `backup`, Status: TaskCrashed}, true},
+		{RetentionRule{Statuses: []int{TaskFailed, TaskCrashed}},
+			Task{Name: `backup`, Status: TaskFinished}, false},
+		{RetentionRule{Script: `backup`, Statuses: []int{TaskFinished}},
+			Task{Name: `backup`, Status: TaskWaiting}, false},
+	} {
+		if got := test.rule.match(&test.task); got != test.want {
+			t.Errorf(`%+v %s/%d: %v != %v`, test.rule, test.task.Name, test.task.Status, got,
+				test.want)
+		}
+	}
+}
+
+func TestPurgeList(t *testing.T) {
+	now := time.Now()
+	ago := func(days int) int64 {
+		return now.AddDate(0, 0, -days).Add(time.Minute).Unix()
+	}
+	prev := storage.Settings
+	defer func() {
+		storage.Settings = prev
+	}()
+	setTestTasks(
+		&Task{ID: 1, Name: `backup`, Status: TaskFinished, StartTime: ago(0)},
+		&Task{ID: 2, Name: `backup`, Status: TaskFinished, StartTime: ago(1)},
+		&Task{ID: 3, Name: `backup`, Status: TaskFailed, StartTime: ago(2)},
+		&Task{ID: 4, Name: `backup`, Status: TaskFinished, StartTime: ago(3), Locked: true},
+		&Task{ID: 5, Name: `report`, Status: TaskFailed, StartTime: ago(4)},
+		&Task{ID: 6, Name: `report`, Status: TaskActive, StartTime: ago(5)},
+		&Task{ID: 7, Name: `report`, Status: TaskFinished, StartTime: ago(40)},
+	)
+	for _, test := range []struct {
+		rules []RetentionRule
+		want  string
+	}{
+		{nil, `[7:-1]`},
+		{[]RetentionRule{{Script: `backup`, MaxTasks: 1}}, `[2:0 3:0 7:-1]`},
+		{[]RetentionRule{{Script: `backup`, Statuses: []int{TaskFinished}, MaxTasks: 1}},
+			`[2:0 7:-1]`},
+		{[]RetentionRule{{Statuses: []int{TaskFailed}, Days: 3}}, `[5:0 7:-1]`},
+		{[]RetentionRule{{Script: `report`, Days: 60}, {MaxTasks: 2}}, `[3:1]`},
+		{[]RetentionRule{{MaxTasks: 0, Days: 0}}, `[]`},
+	} {
+		storage.Settings.Retention = test.rules
+		storage.Settings.MaxTasks = 100
+		storage.Settings.RemoveAfter = 30
+		list := make([]string, 0)
+		for _, item := range purgeList() {
+			list = append(list, fmt.Sprintf(`%d:%d`, item.ID, item.Rule))
+		}
+		if got := fmt.Sprint(list); got != test.want {
+			t.Errorf(`%+v: %s != %s`, test.rules, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"eonza/users"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+const (
+	// RetryLimit is the maximum number of retry attempts
+	RetryLimit = 10
+	// DefRetryDelay is the default delay before the first retry in seconds
+	DefRetryDelay = 60
+)
+
+// Retry contains the retry settings of timers and events
+type Retry struct {
+	Attempts int     `json:"attempts"`           // maximum number of retries, 0 - disabled
+	Delay    int     `json:"delay"`              // delay before the first retry in seconds
+	Factor   float64 `json:"factor"`             // backoff factor of the delay
+	Statuses []int   `json:"statuses,omitempty"` // final statuses to retry, TaskFailed and TaskCrashed by default
+}
+
+// Validate checks and normalizes the retry settings
+func (retry *Retry) Validate() error {
+	if retry.Attempts < 0 || retry.Attempts > RetryLimit {
+		return fmt.Errorf(`the number of retry attempts must be between 0 and %d`, RetryLimit)
+	}
+	if retry.Delay <= 0 {
+		retry.Delay = DefRetryDelay
+	}
+	if retry.Factor < 1 {
+		retry.Factor = 1
+	}
+	for _, status := range retry.Statuses {
+		if status < TaskFinished || status >= len(taskStatusNames) {
+			return fmt.Errorf(`invalid retry status %d`, status)
+		}
+	}
+	return nil
+}
+
+// IsRetry returns true if the task with the status should be retried
+func (retry *Retry) IsRetry(status int) bool {
+	if len(retry.Statuses) == 0 {
+		return status == TaskFailed || status == TaskCrashed
+	}
+	for _, item := range retry.Statuses {
+		if item == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay before the attempt
+func (retry *Retry) Backoff(attempt int) time.Duration {
+	delay := float64(retry.Delay) * math.Pow(retry.Factor, float64(attempt-1))
+	return time.Duration(delay * float64(time.Second))
+}
+
+// taskRetry returns the retry settings of the timer or event which has started the task
+func taskRetry(ptask *Task) *Retry {
+	switch ptask.RoleID {
+	case users.TimersID:
+		if timer, ok := storage.Timers[ptask.UserID]; ok {
+			return &timer.Retry
+		}
+	case users.EventsID:
+		for _, event := range storage.Events {
+			if event.ID == ptask.UserID {
+				return &event.Retry
+			}
+		}
+	}
+	return nil
+}
+
+// CheckRetry schedules the next attempt of the finished task if it is required. The parameters
+// of the run are taken from the task, so the tasks crashed before the restart are retried too.
+// It returns true if the attempt has been scheduled.
+func CheckRetry(ptask *Task) bool {
+	retry := taskRetry(ptask)
+	if retry == nil || retry.Attempts == 0 || !retry.IsRetry(ptask.Status) ||
+		ptask.Attempt >= retry.Attempts {
+		return false
+	}
+	next, err := taskRunScript(ptask)
+	if err != nil {
+		golog.Error(err)
+		return false
+	}
+	next.RetryOf = ptask.ID
+	next.Attempt = ptask.Attempt + 1
+	if ptask.RetryOf != 0 {
+		next.RetryOf = ptask.RetryOf
+	}
+	time.AfterFunc(retry.Backoff(next.Attempt), func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err := systemRun(next); err != nil && err != ErrRunSkipped {
+			NewNotification(&Notification{
+				Text: fmt.Sprintf(`Retry %d of task %x error: %s`, next.Attempt, next.RetryOf,
+					err.Error()),
+				UserID: next.User.ID,
+				RoleID: next.Role.ID,
+				Script: next.Name,
+			})
+		}
+	})
+	return true
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for _, test := range []struct {
+		retry   Retry
+		attempt int
+		want    time.Duration
+	}{
+		{Retry{Delay: 60, Factor: 1}, 1, time.Minute},
+		{Retry{Delay: 60, Factor: 1}, 5, time.Minute},
+		{Retry{Delay: 10, Factor: 2}, 1, 10 * time.Second},
+		{Retry{Delay: 10, Factor: 2}, 2, 20 * time.Second},
+		{Retry{Delay: 10, Factor: 2}, 4, 80 * time.Second},
+		{Retry{Delay: 30, Factor: 1.5}, 3, 67500 * time.Millisecond},
+		{Retry{Delay: 1, Factor: 3}, 10, 19683 * time.Second},
+	} {
+		if got := test.retry.Backoff(test.attempt); got != test.want {
+			t.Errorf(`%+v attempt %d: %v != %v`, test.retry, test.attempt, got, test.want)
+		}
+	}
+}
+
+func TestRetryValidate(t *testing.T) {
+	for _, test := range []struct {
+		retry Retry
+		want  Retry
+		err   bool
+	}{
+		{Retry{}, Retry{Delay: DefRetryDelay, Factor: 1}, false},
+		{Retry{Attempts: 3, Delay: 5, Factor: 0.5}, Retry{Attempts: 3, Delay: 5, Factor: 1}, false},
+		{Retry{Attempts: 2, Delay: -1, Factor: 2}, Retry{Attempts: 2, Delay: DefRetryDelay,
+			Factor: 2}, false},
+		{Retry{Attempts: RetryLimit + 1}, Retry{}, true},
+		{Retry{Attempts: -1}, Retry{}, true},
+		{Retry{Attempts: 1, Statuses: []int{TaskActive}}, Retry{}, true},
+		{Retry{Attempts: 1, Statuses: []int{len(taskStatusNames)}}, Retry{}, true},
+	} {
+		retry := test.retry
+		err := retry.Validate()
+		if (err != nil) != test.err {
+			t.Errorf(`%+v: error %v`, test.retry, err)
+		} else if !test.err && (retry.Attempts != test.want.Attempts ||
+			retry.Delay != test.want.Delay || retry.Factor != test.want.Factor) {
+			t.Errorf(`%+v: %+v != %+v`, test.retry, retry, test.want)
+		}
+	}
+}
+
+func TestRetryIsRetry(t *testing.T) {
+	for _, test := range []struct {
+		statuses []int
+		status   int
+		want     bool
+	}{
+		{nil, TaskFailed, true},
+		{nil, TaskCrashed, true},
+		{nil, TaskFinished, false},
+		{nil, TaskTimeout, false},
+		{[]int{TaskTimeout}, TaskTimeout, true},
+		{[]int{TaskTimeout}, TaskFailed, false},
+		{[]int{TaskFinished, TaskTerminated}, TaskTerminated, true},
+	} {
+		retry := Retry{Statuses: test.statuses}
+		if got := retry.IsRetry(test.status); got != test.want {
+			t.Errorf(`%v %d: %v != %v`, test.statuses, test.status, got, test.want)
+		}
+	}
+}
@@ -5,45 +5,53 @@
 package main
 
 import (
+	"bytes"
 	"eonza/lib"
 	"eonza/script"
 	"eonza/users"
 	"fmt"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
 type RunScript struct {
-	Name    string
-	Open    bool
-	Console bool
-	User    users.User
-	Role    users.Role
-	IP      string
-	Data    string
+	Name      string
+	Open      bool
+	Console   bool
+	User      users.User
+	Role      users.Role
+	IP        string
+	Data      string
+	RetryOf   uint32             // the original task if it is a retry
+	Attempt   int                // the number of the retry attempt
+	RestartOf uint32             // the crashed task if it has been restarted at startup
+	Args      string             // the command-line arguments of the re-run console script
+	Replay    []script.FormInput // the recorded form values of the re-run
+	Agent     string             // the name or the label of the agent to run the script on
+	Priority  int                // the priority of the queued run, 0 - the script setting
+	Depth     int                // the number of the triggers which have led to the run
 
 	// Result fields
 	ID      uint32
-	Port    int
 	Encoded []byte
+	Queued  bool
+
+	fromQueue bool   // the run has been taken from the queue
+	agent     string // the agent which runs the task
 }
 
+// systemRun starts the task of the script or adds the run to the queue, the global mutex must be
+// locked
 func systemRun(rs *RunScript) error {
 	var (
 		item     *Script
 		src      string
 		langCode string
 		langid   int
+		err      error
 	)
-	port, err := getPort()
-	if err != nil {
-		return err
-	}
-	localPort, err := getPort()
-	if err != nil {
-		return err
-	}
 	var (
 		formAlign uint32
 		userID    uint32
@@ -70,6 +78,30 @@ func systemRun(rs *RunScript) error {
 	if item.Settings.Unrun {
 		return fmt.Errorf(Lang(langid, `errnorun`, rs.Name))
 	}
+	if queued, err := checkInstances(item, rs); err != nil || queued {
+		return err
+	}
+	if queued, err := checkRunning(item, rs); err != nil || queued {
+		return err
+	}
+	var agent *AgentInfo
+	target := rs.Agent
+	if len(target) == 0 {
+		target = item.Settings.Agent
+	}
+	if len(target) > 0 && !rs.Console {
+		if agent, err = pickAgent(target); err != nil {
+			return err
+		}
+		rs.agent = agent.Name
+	}
+	claimKey := cfg.HTTP.JWTKey + sessionKey
+	if agent != nil {
+		// the agent must not get the key of the user tokens of the main web-server
+		if claimKey, err = agentClaimKey(); err != nil {
+			return err
+		}
+	}
 	title := item.Settings.Title
 	if langTitle := strings.Trim(title, `#`); langTitle != title {
 		if val, ok := item.Langs[langCode][langTitle]; ok {
@@ -90,29 +122,33 @@ func systemRun(rs *RunScript) error {
 		LogDir:       cfg.Log.Dir,
 		CDN:          cdn,
 		Data:         rs.Data,
+		Args:         rs.Args,
+		Replay:       rs.Replay,
 		Console:      rs.Console,
 		IsPlayground: cfg.playground,
 		IsAutoFill:   IsAutoFill(),
+		JSONLog:      storage.Settings.JSONLog,
+		StripANSI:    item.Settings.StripANSI,
 		IP:           rs.IP,
 		User:         rs.User,
 		Role:         rs.Role,
-		ClaimKey:     cfg.HTTP.JWTKey + sessionKey,
+		ClaimKey:     claimKey,
 		IsPro:        IsProActive(), //storage.Trial.Mode > TrialOff,
 		Constants:    storage.Settings.Constants,
 		SecureConsts: SecureConstants(),
 		Lang:         langCode,
 		TaskID:       lib.RndNum(),
 		FormAlign:    formAlign,
+		GracePeriod:  int(gracePeriod(item) / time.Second),
+		BufferLines:  item.Settings.BufferLines,
 		ServerPort:   cfg.HTTP.LocalPort,
 		URLPort:      cfg.HTTP.Port,
 		HTTP: &lib.HTTPConfig{
-			Host:      cfg.HTTP.Host,
-			Port:      port,
-			LocalPort: localPort,
-			Open:      rs.Open,
-			Theme:     cfg.HTTP.Theme,
-			Cert:      cfg.HTTP.Cert,
-			Priv:      cfg.HTTP.Priv,
+			Host:  cfg.HTTP.Host,
+			Open:  rs.Open,
+			Theme: cfg.HTTP.Theme,
+			Cert:  cfg.HTTP.Cert,
+			Priv:  cfg.HTTP.Priv,
 		},
 	}
 	if len(item.pkg) > 0 {
@@ -120,15 +156,6 @@ func systemRun(rs *RunScript) error {
 	}
 	if header.IsPlayground {
 		header.Playground = &cfg.Playground
-		tasksLimit := cfg.Playground.Tasks
-		for _, item := range tasks {
-			if item.Status < TaskFinished {
-				tasksLimit--
-			}
-		}
-		if tasksLimit <= 0 {
-			return fmt.Errorf(Lang(langid, `errtasklimit`, cfg.Playground.Tasks))
-		}
 	}
 	if src, err = GenSource(item, &header); err != nil {
 		return err
@@ -138,9 +165,19 @@ func systemRun(rs *RunScript) error {
 			return err
 		}
 	}
-	data, err := script.Encode(header, src)
-	if err != nil {
-		return err
+	var data *bytes.Buffer
+	if agent != nil {
+		if data, err = script.EncodeSource(header, src); err != nil {
+			return err
+		}
+	} else {
+		var command *exec.Cmd
+		if data, command, err = script.Encode(header, src); err != nil {
+			return err
+		}
+		if command != nil {
+			WatchProcess(header.TaskID, command)
+		}
 	}
 	if !Licensed() && storage.Trial.Mode == TrialOn {
 		now := time.Now()
@@ -156,13 +193,17 @@ func systemRun(rs *RunScript) error {
 			}
 		}
 	}
-	if err = NewTask(header); err != nil {
+	if err = NewTask(header, rs); err != nil {
 		return err
 	}
+	if agent != nil {
+		if err = runAgentTask(agent, header.TaskID, data); err != nil {
+			return err
+		}
+	}
 	if rs.Console {
 		rs.Encoded = data.Bytes()
 	}
-	rs.Port = header.HTTP.Port
 	rs.ID = header.TaskID
 
 	return nil
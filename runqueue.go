@@ -0,0 +1,374 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"eonza/lib"
+	"eonza/users"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gentee/gentee"
+	"github.com/kataras/golog"
+	"github.com/labstack/echo/v4"
+)
+
+const ( // The policies of extra runs when the script has reached MaxInstances
+	LimitSkip = iota
+	LimitQueue
+	LimitReplace
+)
+
+const (
+	// RunQueue is the file of the queued runs
+	RunQueue = `tasks.queue`
+	// QueueInputsExt is the extension of the private file with the parameters of the queued run
+	QueueInputsExt = `qrun`
+)
+
+// queueItem is the queued run. RunQueue contains only the fields which identify the run, its
+// data, arguments and form values are kept in the private file of the run inputs.
+type queueItem struct {
+	ID       uint32    `json:"id"`
+	Name     string    `json:"name"`
+	UserID   uint32    `json:"userid"`
+	RoleID   uint32    `json:"roleid"`
+	Priority int       `json:"priority"`
+	Added    time.Time `json:"added"`
+	Inputs   string    `json:"inputs"` // the file of the run inputs
+
+	run *RunScript // nil if the run has been loaded from RunQueue
+}
+
+// QueueInfo describes the queued run in the task list
+type QueueInfo struct {
+	ID       uint32 `json:"id"`
+	Position int    `json:"position"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority,omitempty"`
+	User     string `json:"user"`
+	Role     string `json:"role"`
+	Added    string `json:"added"`
+}
+
+var (
+	// ErrRunSkipped is returned when the script has been skipped by the concurrency policy
+	ErrRunSkipped = errors.New(`the run has been skipped`)
+	// ErrConsoleQueue is returned to the console run which cannot be started immediately
+	ErrConsoleQueue = errors.New(`the console run cannot be queued`)
+
+	// runQueue is sorted by priority, the runs with the same priority are in the order of adding
+	runQueue   []*queueItem
+	queueMutex = &sync.Mutex{}
+)
+
+func queuePath() string {
+	return filepath.Join(cfg.Log.Dir, RunQueue)
+}
+
+// LoadRunQueue loads the queued runs which have not been started before the shutdown
+func LoadRunQueue() error {
+	data, err := os.ReadFile(queuePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	return json.Unmarshal(data, &runQueue)
+}
+
+// saveRunQueue writes the queue to the disk, queueMutex must be locked
+func saveRunQueue() {
+	data, err := json.Marshal(runQueue)
+	if err == nil {
+		err = os.WriteFile(queuePath(), data, 0600)
+	}
+	if err == nil {
+		// the file could have been created with wider permissions
+		err = os.Chmod(queuePath(), 0600)
+	}
+	if err != nil {
+		golog.Error(err)
+	}
+}
+
+// saveQueueInputs writes the parameters of the run to the file which is readable only by the
+// owner of the process. The user and the role are restored by their identifiers.
+func saveQueueInputs(qitem *queueItem) error {
+	run := *qitem.run
+	run.User = users.User{}
+	run.Role = users.Role{}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	qitem.Inputs = fmt.Sprintf(`%08x.%s`, qitem.ID, QueueInputsExt)
+	return os.WriteFile(filepath.Join(cfg.Log.Dir, qitem.Inputs), data, 0600)
+}
+
+func removeQueueInputs(qitem *queueItem) {
+	if len(qitem.Inputs) > 0 {
+		os.Remove(filepath.Join(cfg.Log.Dir, qitem.Inputs))
+	}
+}
+
+// runScript returns the run of the queued item. The run which has been loaded from RunQueue is
+// restored from the file of the run inputs and the user is taken from the storage.
+func (qitem *queueItem) runScript() (*RunScript, error) {
+	if qitem.run != nil {
+		return qitem.run, nil
+	}
+	var rs RunScript
+	if len(qitem.Inputs) > 0 {
+		data, err := os.ReadFile(filepath.Join(cfg.Log.Dir, qitem.Inputs))
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &rs); err != nil {
+			return nil, err
+		}
+	}
+	rs.Name = qitem.Name
+	rs.Priority = qitem.Priority
+	var err error
+	if rs.User, rs.Role, err = runUser(qitem.UserID, qitem.RoleID); err != nil {
+		return nil, err
+	}
+	qitem.run = &rs
+	return &rs, nil
+}
+
+// runningLimit returns the maximum number of running tasks, 0 - unlimited
+func runningLimit() int {
+	if cfg.playground {
+		return int(cfg.Playground.Tasks)
+	}
+	return storage.Settings.MaxRunning
+}
+
+// activeTasks returns the number of unfinished tasks. The global mutex must be locked so that
+// the check of the limit and the start of the task are not interleaved with other runs.
+func activeTasks() (count int) {
+	for _, item := range tasks {
+		if item.Status < TaskFinished {
+			count++
+		}
+	}
+	return
+}
+
+// enqueueRun adds the run to the queue according to its priority. Console runs are refused
+// because the console waits for the answer.
+func enqueueRun(item *Script, rs *RunScript) (bool, error) {
+	if rs.Console {
+		return false, ErrConsoleQueue
+	}
+	qitem := &queueItem{
+		ID:       lib.RndNum(),
+		Name:     rs.Name,
+		UserID:   rs.User.ID,
+		RoleID:   rs.User.RoleID,
+		Priority: rs.Priority,
+		Added:    time.Now(),
+		run:      rs,
+	}
+	if rs.Role.ID >= users.ResRoleID {
+		qitem.RoleID = rs.Role.ID
+	}
+	if qitem.Priority == 0 {
+		qitem.Priority = item.Settings.Priority
+	}
+	if err := saveQueueInputs(qitem); err != nil {
+		return false, err
+	}
+	rs.Queued = true
+	queueMutex.Lock()
+	i := sort.Search(len(runQueue), func(i int) bool {
+		return runQueue[i].Priority < qitem.Priority
+	})
+	runQueue = append(runQueue, nil)
+	copy(runQueue[i+1:], runQueue[i:])
+	runQueue[i] = qitem
+	saveRunQueue()
+	queueMutex.Unlock()
+	return true, nil
+}
+
+// checkRunning adds the run to the queue if the maximum number of running tasks has been reached
+func checkRunning(item *Script, rs *RunScript) (bool, error) {
+	limit := runningLimit()
+	if limit <= 0 || rs.fromQueue || activeTasks() < limit {
+		return false, nil
+	}
+	return enqueueRun(item, rs)
+}
+
+// activeInstances returns the unfinished tasks of the script sorted by start time
+func activeInstances(name string) []*Task {
+	ret := make([]*Task, 0)
+	for _, item := range tasksByName[lib.IdName(name)] {
+		if item.Status < TaskFinished {
+			ret = append(ret, item)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].StartTime < ret[j].StartTime
+	})
+	return ret
+}
+
+// TerminateTask sends the terminate command to the task process
+func TerminateTask(ptask *Task) {
+	taskGet(ptask, fmt.Sprintf("sys?cmd=%d&taskid=%d", gentee.SysTerminate, ptask.ID))
+}
+
+// checkInstances applies the concurrency policy of the script. It returns true if the run has
+// been added to the queue.
+func checkInstances(item *Script, rs *RunScript) (bool, error) {
+	max := item.Settings.MaxInstances
+	if max <= 0 || rs.fromQueue {
+		return false, nil
+	}
+	active := activeInstances(rs.Name)
+	if len(active) < max {
+		return false, nil
+	}
+	switch item.Settings.OnLimit {
+	case LimitQueue:
+		return enqueueRun(item, rs)
+	case LimitReplace:
+		for i := 0; i <= len(active)-max; i++ {
+			CancelTask(active[i])
+		}
+		return false, nil
+	}
+	if err := NewNotification(&Notification{
+		Text: fmt.Sprintf(`The %s script has been skipped, %d instances are running`,
+			rs.Name, len(active)),
+		UserID: rs.User.ID,
+		RoleID: rs.User.RoleID,
+		Script: rs.Name,
+	}); err != nil {
+		golog.Error(err)
+	}
+	return false, ErrRunSkipped
+}
+
+// CheckRunQueue starts the queued runs which scripts have free slots. The global mutex is held
+// across the check of the slots and the start of the tasks.
+func CheckRunQueue() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	queueMutex.Lock()
+	start := make([]*queueItem, 0)
+	limit := runningLimit()
+	if limit > 0 {
+		limit -= activeTasks()
+	}
+	for i := 0; i < len(runQueue); i++ {
+		if runningLimit() > 0 && len(start) >= limit {
+			break
+		}
+		qitem := runQueue[i]
+		if item := getRunScript(qitem.Name); item != nil &&
+			item.Settings.MaxInstances > 0 {
+			running := len(activeInstances(qitem.Name))
+			for _, started := range start {
+				if started.Name == qitem.Name {
+					running++
+				}
+			}
+			if running >= item.Settings.MaxInstances {
+				continue
+			}
+		}
+		start = append(start, qitem)
+		runQueue = append(runQueue[:i], runQueue[i+1:]...)
+		i--
+	}
+	if len(start) > 0 {
+		saveRunQueue()
+	}
+	queueMutex.Unlock()
+	for _, qitem := range start {
+		rs, err := qitem.runScript()
+		removeQueueInputs(qitem)
+		if err == nil {
+			rs.fromQueue = true
+			rs.Queued = false
+			err = systemRun(rs)
+		}
+		if err != nil {
+			NewNotification(&Notification{
+				Text:   fmt.Sprintf(`Queued run error: %s`, err.Error()),
+				UserID: qitem.UserID,
+				RoleID: qitem.RoleID,
+				Script: qitem.Name,
+			})
+		}
+	}
+}
+
+// queueList returns the queued runs which are visible for the user
+func queueList(user *users.User) []QueueInfo {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	ret := make([]QueueInfo, 0)
+	for i, qitem := range runQueue {
+		if user.RoleID != users.XAdminID && user.ID != qitem.UserID {
+			continue
+		}
+		userName, roleName := GetUserRole(qitem.UserID, qitem.RoleID)
+		ret = append(ret, QueueInfo{
+			ID:       qitem.ID,
+			Position: i + 1,
+			Name:     qitem.Name,
+			Priority: qitem.Priority,
+			User:     userName,
+			Role:     roleName,
+			Added:    qitem.Added.Format(TimeFormat),
+		})
+	}
+	return ret
+}
+
+func cancelRunHandle(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param(`id`), 10, 32)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	user := c.(*Auth).User
+	var qitem *queueItem
+	queueMutex.Lock()
+	for i, item := range runQueue {
+		if item.ID == uint32(id) {
+			if user.RoleID != users.XAdminID && user.ID != item.UserID {
+				queueMutex.Unlock()
+				return jsonError(c, fmt.Errorf(`Access denied`))
+			}
+			qitem = item
+			runQueue = append(runQueue[:i], runQueue[i+1:]...)
+			saveRunQueue()
+			break
+		}
+	}
+	queueMutex.Unlock()
+	if qitem == nil {
+		return jsonError(c, fmt.Errorf(`queued run %d has not been found`, id))
+	}
+	removeQueueInputs(qitem)
+	return c.JSON(http.StatusOK, &TasksResponse{Queue: queueList(user)})
+}
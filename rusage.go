@@ -0,0 +1,31 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// IOBlockSize is the size of the blocks counted by rusage
+const IOBlockSize = 512
+
+// processUsage returns the resources used by the exited task process and its children
+func processUsage(state *os.ProcessState) *TaskUsage {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return nil
+	}
+	return &TaskUsage{
+		UserTime:   rusage.Utime.Nano() / 1e6,
+		SystemTime: rusage.Stime.Nano() / 1e6,
+		MaxRSS:     rusage.Maxrss << 10, // kilobytes on Linux
+		ReadBytes:  rusage.Inblock * IOBlockSize,
+		WriteBytes: rusage.Oublock * IOBlockSize,
+	}
+}
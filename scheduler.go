@@ -22,11 +22,14 @@ import (
 )
 
 type TimerCommon struct {
-	ID     uint32 `json:"id"`
-	Name   string `json:"name"`
-	Script string `json:"script"`
-	Cron   string `json:"cron"`
-	Active bool   `json:"active"`
+	ID       uint32 `json:"id"`
+	Name     string `json:"name"`
+	Script   string `json:"script"`
+	Cron     string `json:"cron"`
+	Active   bool   `json:"active"`
+	Retry    Retry  `json:"retry"`
+	Agent    string `json:"agent,omitempty"`    // the agent name or label, the script setting by default
+	Priority int    `json:"priority,omitempty"` // the priority of the queued run, the script setting by default
 }
 
 type TimerInfo struct {
@@ -54,6 +57,8 @@ type Event struct {
 	Token     string `json:"token"`
 	Whitelist string `json:"whitelist"`
 	Active    bool   `json:"active"`
+	Retry     Retry  `json:"retry"`
+	Priority  int    `json:"priority,omitempty"` // the priority of the queued run, the script setting by default
 }
 
 type EventData struct {
@@ -113,12 +118,19 @@ func GetSchedulerName(id, idrole uint32) (uname string, rname string) {
 			}
 		}
 		rname = users.EventsRole
+	case users.TriggerID:
+		if trigger, ok := storage.Triggers[id]; ok {
+			uname = trigger.Name
+		}
+		rname = users.TriggerRole
 	}
 
 	return
 }
 
 func (timer *Timer) Run() {
+	mutex.Lock()
+	defer mutex.Unlock()
 	if cfg.playground {
 		NewNotification(&Notification{
 			Text:   `Scheduler can't run scripts in playground mode`,
@@ -139,9 +151,11 @@ func (timer *Timer) Run() {
 			ID:   users.TimersID,
 			Name: users.TimersRole,
 		},
-		IP: Localhost,
+		IP:       Localhost,
+		Agent:    timer.Agent,
+		Priority: timer.Priority,
 	}
-	if err := systemRun(&rs); err != nil {
+	if err := systemRun(&rs); err != nil && err != ErrRunSkipped {
 		NewNotification(&Notification{
 			Text:   fmt.Sprintf(`Scheduler error: %s`, err.Error()),
 			UserID: timer.ID,
@@ -198,6 +212,9 @@ func saveTimerHandle(c echo.Context) error {
 	if len(timer.Script) == 0 {
 		return jsonError(c, Lang(DefLang, `errreq`, `Script`))
 	}
+	if err := timer.Retry.Validate(); err != nil {
+		return jsonError(c, err)
+	}
 	for _, item := range storage.Timers {
 		if len(timer.Name) > 0 && strings.ToLower(timer.Name) == strings.ToLower(item.Name) &&
 			timer.ID != item.ID {
@@ -292,6 +309,9 @@ func saveEventHandle(c echo.Context) error {
 	if len(event.Name) == 0 {
 		return jsonError(c, Lang(DefLang, `errreq`, `Name`))
 	}
+	if err := event.Retry.Validate(); err != nil {
+		return jsonError(c, err)
+	}
 	var curKey string
 	for _, item := range storage.Events {
 		if strings.ToLower(event.Name) == strings.ToLower(item.Name) && event.ID != item.ID {
@@ -354,10 +374,18 @@ func eventHandle(c echo.Context) error {
 		event     *Event
 		ok        bool
 	)
+	// unknown events are counted with the empty name
+	name, result := ``, EventDeny
+	defer func() {
+		CountEvent(name, result)
+	}()
 	if err = c.Bind(&eventData); err != nil {
 		return jsonError(c, err)
 	}
-	if event, ok = storage.Events[eventData.Name]; !ok || !event.Active {
+	if event, ok = storage.Events[eventData.Name]; ok {
+		name = event.Name
+	}
+	if !ok || !event.Active {
 		return AccessDenied(http.StatusForbidden)
 	}
 	ip := c.RealIP()
@@ -427,12 +455,15 @@ func eventHandle(c echo.Context) error {
 			ID:   users.EventsID,
 			Name: users.EventsRole,
 		},
-		IP: ip,
+		IP:       ip,
+		Priority: event.Priority,
 	}
 	if err := systemRun(&rs); err != nil {
+		result = EventError
 		return jsonError(c, err)
 	}
-	return c.JSON(http.StatusOK, RunResponse{Success: true, Port: rs.Port, ID: rs.ID})
+	result = EventHit
+	return c.JSON(http.StatusOK, runResponse(&rs))
 }
 
 func randidHandle(c echo.Context) error {
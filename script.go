@@ -35,6 +35,29 @@ type scriptSettings struct {
 	Unrun    bool   `json:"unrun,omitempty" yaml:"unrun,omitempty"`
 	Help     string `json:"help,omitempty" yaml:"help,omitempty"`
 	HelpLang string `json:"helplang,omitempty" yaml:"helplang,omitempty"`
+	// MaxInstances is the maximum number of concurrent runs, 0 - unlimited
+	MaxInstances int `json:"maxinstances,omitempty" yaml:"maxinstances,omitempty"`
+	// OnLimit is the policy of extra runs: LimitSkip, LimitQueue or LimitReplace
+	OnLimit int `json:"onlimit,omitempty" yaml:"onlimit,omitempty"`
+	// Timeout is the maximum run duration in minutes, 0 - the global setting
+	Timeout int `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// WaitTimeout is the maximum time of waiting for a form in minutes, 0 - the global setting
+	WaitTimeout int `json:"waittimeout,omitempty" yaml:"waittimeout,omitempty"`
+	// OnCrash is the policy of the task found crashed at startup: CrashIgnore, CrashNotify or
+	// CrashRestart
+	OnCrash int `json:"oncrash,omitempty" yaml:"oncrash,omitempty"`
+	// GracePeriod is the time in seconds for the finally blocks after the cancel request,
+	// 0 - KillTimeout
+	GracePeriod int `json:"graceperiod,omitempty" yaml:"graceperiod,omitempty"`
+	// Agent is the name or the label of the agent to run the script on, empty - the main server
+	Agent string `json:"agent,omitempty" yaml:"agent,omitempty"`
+	// Priority is the priority of the queued runs, the runs with the higher priority start first
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty"`
+	// BufferLines is the number of the console and log lines of the running task which are kept
+	// in memory, 0 - DefBufferLines
+	BufferLines int `json:"bufferlines,omitempty" yaml:"bufferlines,omitempty"`
+	// StripANSI removes the colors and other terminal escape sequences from the .out file
+	StripANSI bool `json:"stripansi,omitempty" yaml:"stripansi,omitempty"`
 }
 
 type scriptTree struct {
@@ -0,0 +1,92 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	ArtifactExt  = `eoa` // the list of artifacts
+	ArtifactFile = `art` // the extension of artifact files
+)
+
+// Artifact is the file which has been attached to the task
+type Artifact struct {
+	Title string `json:"title"`
+	Name  string `json:"name"` // the name of the original file
+	File  string `json:"file"` // the name of the file in the task archive
+	Size  int64  `json:"size"`
+}
+
+var (
+	artifacts     []Artifact
+	artifactMutex = &sync.Mutex{}
+)
+
+func copyArtifact(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// AttachArtifact copies the file into the log directory. It is added to the task archive when
+// the task has been finished.
+func AttachArtifact(path, title string) error {
+	if scriptTask.Header.IsPlayground {
+		return fmt.Errorf(`Access denied`)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf(`%s is not a file`, path)
+	}
+	if len(title) == 0 {
+		title = fi.Name()
+	}
+	artifactMutex.Lock()
+	defer artifactMutex.Unlock()
+	artifact := Artifact{
+		Title: title,
+		Name:  fi.Name(),
+		File:  fmt.Sprintf(`%08x.%d.%s`, scriptTask.Header.TaskID, len(artifacts), ArtifactFile),
+		Size:  fi.Size(),
+	}
+	if err = copyArtifact(path, filepath.Join(scriptTask.Header.LogDir, artifact.File)); err != nil {
+		return err
+	}
+	artifacts = append(artifacts, artifact)
+	data, err := json.Marshal(artifacts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(scriptTask.Header.LogDir,
+		fmt.Sprintf(`%08x.%s`, scriptTask.Header.TaskID, ArtifactExt)), data, 0666)
+}
+
+// Artifacts returns the attached artifacts
+func Artifacts() []Artifact {
+	artifactMutex.Lock()
+	defer artifactMutex.Unlock()
+	return append([]Artifact{}, artifacts...)
+}
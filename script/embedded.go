@@ -41,10 +41,18 @@ const (
 	EonzaDynamic = `eonza.dynamic.constant`
 )
 
+// The actions when the form has not been filled out in time
+const (
+	FormTimeoutDefault = iota // submit the default values
+	FormTimeoutSkip           // skip the form
+	FormTimeoutFail           // fail the script
+)
+
 type PostNfy struct {
 	TaskID uint32
 	Text   string `json:"text"`
 	Script string
+	Role   string `json:"role,omitempty"` // the role of the recipients instead of the task role
 }
 
 type PostScript struct {
@@ -91,18 +99,23 @@ type ScriptOptions struct {
 }
 
 type FormData struct {
-	AutoFill bool                     `json:"autofill"`
-	List     []map[string]interface{} `json:"list"`
+	AutoFill  bool                     `json:"autofill"`
+	List      []map[string]interface{} `json:"list"`
+	Timeout   int64                    `json:"timeout,omitempty"` // in seconds
+	OnTimeout int64                    `json:"ontimeout,omitempty"`
 }
 
 type FormDataStack struct {
-	AutoFill bool        `json:"autofill"`
-	List     []FormParam `json:"list"`
+	AutoFill  bool        `json:"autofill"`
+	List      []FormParam `json:"list"`
+	Timeout   int64       `json:"timeout,omitempty"`
+	OnTimeout int64       `json:"ontimeout,omitempty"`
 }
 
 type ThreadOptions struct {
 	LogLevel int64
 	Refs     []string
+	Cmds     []string // the stack of running commands
 }
 
 const (
@@ -138,6 +151,17 @@ type FormInfo struct {
 	ChResponse chan bool
 	Data       string
 	ID         uint32
+	Timeout    int64 // in seconds, 0 - without timeout
+	OnTimeout  int64
+	Link       string // the signed URL of the form
+}
+
+// FormInput contains the values entered in the form
+type FormInput struct {
+	Ref    string                 `json:"ref"`
+	Values map[string]interface{} `json:"values"`
+	Skip   bool                   `json:"skip,omitempty"`
+	Masked []string               `json:"masked,omitempty"` // the password fields
 }
 
 type Data struct {
@@ -145,10 +169,11 @@ type Data struct {
 	Vars     []map[string]string
 	ObjVars  []sync.Map
 	Mutex    sync.Mutex
-	chLogout chan string
+	chLogout chan LogEntry
 	chForm   chan FormInfo
 	chReport chan Report
 	Global   *map[string]string
+	Results  map[string]string // result variables of the entry script
 }
 
 const (
@@ -170,6 +195,7 @@ var (
 		`INHERIT`: LOG_INHERIT,
 	}
 	IsTimeout  bool
+	cleanup    bool // the finally blocks of the script are being run
 	Timeout    time.Time
 	MainThread *vm.Runtime
 	formID     uint32
@@ -185,6 +211,7 @@ var (
 		{Prototype: `ref() str`, Object: GetRef},
 		{Prototype: `init()`, Object: Init},
 		{Prototype: `initcmd(int,str) int`, Object: InitCmd},
+		{Prototype: `deinitcmd(int)`, Object: DeinitCmd},
 		{Prototype: `deinit()`, Object: Deinit},
 		{Prototype: `SetTimeout(int)`, Object: SetTimeout},
 		{Prototype: `Condition(map.obj) bool`, Object: MapCondition},
@@ -192,8 +219,11 @@ var (
 		{Prototype: `CopyClipboard(str)`, Object: CopyClipboard},
 		{Prototype: `File(str) str`, Object: FileLoad},
 		{Prototype: `Form(str)`, Object: Form},
+		{Prototype: `Form(str,int,int)`, Object: FormTimeout},
+		{Prototype: `Form(str,int,int,str)`, Object: FormRole},
 		{Prototype: `FillForm(str,str)`, Object: FillForm},
 		{Prototype: `GetClipboard() str`, Object: GetClipboard},
+		{Prototype: `IsCleanup() bool`, Object: IsCleanup},
 		{Prototype: `IsEntry() bool`, Object: IsEntry},
 		{Prototype: `IsVarObj(str) bool`, Object: IsVarObj},
 		{Prototype: `IsVar(str) bool`, Object: IsVar},
@@ -230,6 +260,7 @@ var (
 		{Prototype: `LoadIni(buf) handle`, Object: LoadIni},
 		{Prototype: `GetIniValue(handle,str,str,str,str) bool`, Object: GetIniValue},
 		{Prototype: `CreateReport(str,str)`, Object: CreateReport},
+		{Prototype: `AttachArtifact(str,str)`, Object: AttachArtifact},
 		{Prototype: `AppendToArray(str,str)`, Object: AppendToArray},
 		{Prototype: `AppendToMap(str,str,str)`, Object: AppendToMap},
 		{Prototype: `SetSystemFlags(int) int`, Object: SetSystemFlags},
@@ -551,6 +582,7 @@ func InitCmd(rt *vm.Runtime, logLevel int64, name string, pars ...interface{}) i
 			params[i] = val
 		}
 	}
+	rt.Custom.(*ThreadOptions).Cmds = append(rt.Custom.(*ThreadOptions).Cmds, name)
 	if name != `source-code` {
 		LogOutput(rt, LOG_INFO, fmt.Sprintf("=> %s(%s)", name, strings.Join(params, `, `)))
 	}
@@ -561,6 +593,38 @@ func InitCmd(rt *vm.Runtime, logLevel int64, name string, pars ...interface{}) i
 	return prevLevel
 }
 
+// DeinitCmd finishes the command and restores the previous log level
+func DeinitCmd(rt *vm.Runtime, prevLevel int64) {
+	cmds := rt.Custom.(*ThreadOptions).Cmds
+	if len(cmds) > 0 {
+		rt.Custom.(*ThreadOptions).Cmds = cmds[:len(cmds)-1]
+	}
+	SetLogLevel(rt, prevLevel)
+}
+
+// IsCleanup returns true if the script has been started to run the finally blocks
+func IsCleanup() int64 {
+	if cleanup {
+		return 1
+	}
+	return 0
+}
+
+// PrepareCleanup leaves only the top-level variables of the stopped script for the finally blocks
+func PrepareCleanup() {
+	cleanup = true
+	dataScript.Mutex.Lock()
+	empty := len(dataScript.Vars) == 0
+	if !empty {
+		dataScript.Vars = dataScript.Vars[:1]
+		dataScript.ObjVars = dataScript.ObjVars[:1]
+	}
+	dataScript.Mutex.Unlock()
+	if empty {
+		Init()
+	}
+}
+
 func IsEntry() int64 {
 	dataScript.Mutex.Lock()
 	defer dataScript.Mutex.Unlock()
@@ -765,13 +829,34 @@ func loadForm(data string, form *[]map[string]interface{}) (string, error) {
 }
 
 func Form(rt *vm.Runtime, data string) error {
+	return FormTimeout(rt, data, 0, FormTimeoutDefault)
+}
+
+// FormTimeout shows the form which is answered automatically if it has not been filled out
+// in timeout seconds
+func FormTimeout(rt *vm.Runtime, data string, timeout, onTimeout int64) error {
+	return FormRole(rt, data, timeout, onTimeout, ``)
+}
+
+// FormRole shows the form which can be answered through the signed link. If role is specified
+// then only the users of this role can answer it and the link is sent to them as a notification.
+func FormRole(rt *vm.Runtime, data string, timeout, onTimeout int64, role string) error {
 	var formData FormData
 
+	if timeout < 0 {
+		timeout = 0
+	}
+	if onTimeout < FormTimeoutDefault || onTimeout > FormTimeoutFail {
+		return fmt.Errorf(`invalid timeout action %d`, onTimeout)
+	}
+
 	ch := make(chan bool)
 	formList := make([]map[string]interface{}, 0, 32)
 	ref, err := loadForm(data, &formList)
 	formData.AutoFill = (SysFlags&SYSF_NOAUTOFILL) == 0 && scriptTask.Header.IsAutoFill
 	formData.List = formList
+	formData.Timeout = timeout
+	formData.OnTimeout = onTimeout
 	if len(formList) > 0 {
 		var out []byte
 		if out, err = json.Marshal(formData); err == nil {
@@ -793,26 +878,47 @@ func Form(rt *vm.Runtime, data string) error {
 		ChResponse: ch,
 		Data:       data,
 		ID:         formID,
+		Timeout:    timeout,
+		OnTimeout:  onTimeout,
 	}
 	formID++
+	isConsole := (*dataScript.Global)[`isconsole`] == `true`
 	dataScript.Mutex.Unlock()
+	if !isConsole {
+		if form.Link, err = formLink(form.ID, timeout, role); err != nil {
+			return err
+		}
+		if len(role) > 0 {
+			notifyForm(form.Link, role)
+		}
+	}
 	dataScript.chForm <- form
-	<-ch
+	if !<-ch {
+		return fmt.Errorf(`the form has not been filled out in %d seconds`, timeout)
+	}
 	return nil
 }
 
 func LogOutput(rt *vm.Runtime, level int64, message string) {
-	var mode = []string{``, `ERROR`, `WARN`, `FORM`, `INFO`, `DEBUG`}
 	if level < LOG_ERROR || level > LOG_DEBUG {
 		return
 	}
 	dataScript.Mutex.Lock()
 	defer dataScript.Mutex.Unlock()
-	if level > rt.Custom.(*ThreadOptions).LogLevel {
+	options := rt.Custom.(*ThreadOptions)
+	if level > options.LogLevel {
 		return
 	}
-	dataScript.chLogout <- fmt.Sprintf("[%s] %s %s",
-		mode[level], time.Now().Format(`2006/01/02 15:04:05`), message)
+	entry := LogEntry{
+		Time:    time.Now(),
+		Level:   LogLevels[level],
+		Ref:     strings.Join(options.Refs, `/`),
+		Message: message,
+	}
+	if len(options.Cmds) > 0 {
+		entry.Command = options.Cmds[len(options.Cmds)-1]
+	}
+	dataScript.chLogout <- entry
 }
 
 func replace(values map[string]string, input []rune, stack *[]string,
@@ -958,11 +1064,25 @@ func setRawVar(shift int, name, value string) error {
 
 func ResultVar(name, value string) error {
 	if IsEntry() == 1 {
+		dataScript.Mutex.Lock()
+		defer dataScript.Mutex.Unlock()
+		dataScript.Results[name] = value
 		return nil
 	}
 	return setRawVar(1, name, value)
 }
 
+// TaskResults returns the result variables of the entry script
+func TaskResults() map[string]string {
+	dataScript.Mutex.Lock()
+	defer dataScript.Mutex.Unlock()
+	ret := make(map[string]string, len(dataScript.Results))
+	for key, val := range dataScript.Results {
+		ret[key] = val
+	}
+	return ret
+}
+
 func SetVar(name, value string) error {
 	return setRawVar(0, name, value)
 }
@@ -995,8 +1115,9 @@ func SetYamlVars(in string) error {
 	return nil
 }
 
-func InitData(chLogout chan string, chForm chan FormInfo, chReport chan Report, glob *map[string]string) {
+func InitData(chLogout chan LogEntry, chForm chan FormInfo, chReport chan Report, glob *map[string]string) {
 	dataScript.Vars = make([]map[string]string, 0, 8)
+	dataScript.Results = make(map[string]string)
 	dataScript.chLogout = chLogout
 	dataScript.chForm = chForm
 	dataScript.chReport = chReport
@@ -25,9 +25,14 @@ type Header struct {
 	Theme        string
 	CDN          string
 	Data         string
+	Args         string      // the command-line arguments of the console script
+	Replay       []FormInput // the recorded form values which are answered automatically
 	Console      bool
 	IsPlayground bool
 	IsAutoFill   bool
+	JSONLog      bool // write the log as JSON lines
+	StripANSI    bool // remove the terminal escape sequences from the .out file
+	Cleanup      bool // the script has finally blocks
 	SourceCode   []byte
 	Constants    map[string]string
 	SecureConsts map[string]string
@@ -40,43 +45,65 @@ type Header struct {
 	IP           string
 	TaskID       uint32
 	FormAlign    uint32
+	GracePeriod  int // the time in seconds between the cancel request and the forced termination
+	BufferLines  int // the number of the console and log lines kept in memory, 0 - by default
 	ServerPort   int
 	URLPort      int
 	HTTP         *lib.HTTPConfig
 	Playground   *lib.PlaygroundConfig
 }
 
-func Encode(header Header, source string) (*bytes.Buffer, error) {
-	var (
-		data bytes.Buffer
-	)
+// Encode compiles the script and starts the task process. It returns the encoded data
+// for console scripts or the started command which must be waited by the caller.
+func Encode(header Header, source string) (*bytes.Buffer, *exec.Cmd, error) {
+	data, err := EncodeSource(header, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Console {
+		return data, nil, nil
+	}
+	command, err := Start(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, command, nil
+}
 
+// EncodeSource compiles the script and returns the encoded header and bytecode
+func EncodeSource(header Header, source string) (*bytes.Buffer, error) {
 	workspace := gentee.New()
 	bcode, _, err := workspace.Compile(source, header.Name)
 	if err != nil {
 		return nil, err
 	}
+	return EncodeScript(&Script{Header: header, Exec: bcode})
+}
+
+// EncodeScript encodes the header and the bytecode of the compiled script
+func EncodeScript(script *Script) (*bytes.Buffer, error) {
+	var data bytes.Buffer
+
 	enc := gob.NewEncoder(&data)
-	if err = enc.Encode(header); err != nil {
+	if err := enc.Encode(script.Header); err != nil {
 		return nil, err
 	}
-	if err = enc.Encode(bcode); err != nil {
+	if err := enc.Encode(script.Exec); err != nil {
 		return nil, err
 	}
-	if header.Console {
-		return &data, nil
-	}
+	return &data, nil
+}
+
+// Start starts the task process with the encoded script
+func Start(data *bytes.Buffer) (*exec.Cmd, error) {
 	command := exec.Command(lib.AppPath())
-	command.Stdin = &data
+	command.Stdin = data
 	command.Stdout = os.Stdout
 	command.Stderr = os.Stderr
-	err = command.Start()
-	go func() {
-		if err == nil {
-			_ = command.Wait()
-		}
-	}()
-	return nil, err
+	if err := command.Start(); err != nil {
+		return nil, err
+	}
+	return command, nil
 }
 
 func Decode(scriptData []byte) (script *Script, err error) {
@@ -0,0 +1,74 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package script
+
+import (
+	"eonza/lib"
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kataras/golog"
+)
+
+// FormLinkExpire is the lifetime of the signed link to the form without timeout
+const FormLinkExpire = 24 * time.Hour
+
+// FormClaims is the payload of the signed link to the waiting form
+type FormClaims struct {
+	TaskID uint32
+	FormID uint32
+	Role   string // the role of the users who can answer the form, empty - any user
+	jwt.StandardClaims
+}
+
+// FormKey returns the key of the form signatures. It differs from the key of the user tokens
+// so the link cannot be used as the authorization.
+func FormKey(claimKey string) []byte {
+	return []byte(claimKey + `.form`)
+}
+
+// formLink returns the signed URL of the form on the main web-server
+func formLink(id uint32, timeout int64, role string) (string, error) {
+	expire := time.Now().Add(FormLinkExpire)
+	if timeout > 0 {
+		expire = time.Now().Add(time.Duration(timeout) * time.Second)
+	}
+	claims := &FormClaims{
+		TaskID: scriptTask.Header.TaskID,
+		FormID: id,
+		Role:   role,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expire.Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(
+		FormKey(scriptTask.Header.ClaimKey))
+	if err != nil {
+		return ``, err
+	}
+	scheme := `https`
+	if lib.IsPrivateHost(scriptTask.Header.HTTP.Host) {
+		scheme = `http`
+	}
+	return fmt.Sprintf(`%s://%s:%d/form/%s`, scheme, scriptTask.Header.HTTP.Host,
+		scriptTask.Header.URLPort, token), nil
+}
+
+// notifyForm sends the link of the form to the users of the role
+func notifyForm(link, role string) {
+	url := html.EscapeString(link)
+	title := html.EscapeString(scriptTask.Header.Title)
+	if _, err := lib.LocalPost(scriptTask.Header.ServerPort, `api/notification`, PostNfy{
+		TaskID: scriptTask.Header.TaskID,
+		Text: fmt.Sprintf((*dataScript.Global)[`formlink`], title,
+			fmt.Sprintf(`<a href="%s" target="_blank">%s</a>`, url, url)),
+		Script: scriptTask.Header.Name,
+		Role:   role,
+	}); err != nil {
+		golog.Error(err)
+	}
+}
@@ -128,6 +128,13 @@ func CmdPkg(cmd string, obj *core.Obj) (*PkgHandle, error) {
 	return PackageResult(unique)
 }
 
+// PkgsCount returns the number of running package processes
+func PkgsCount() int {
+	muPkgs.Lock()
+	defer muPkgs.Unlock()
+	return len(Pkgs)
+}
+
 func CmdNext(handle *PkgHandle) (*PkgHandle, error) {
 	if handle.Finished {
 		return handle, nil
@@ -0,0 +1,102 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogEntry is the entry of the task log
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Ref     string    `json:"ref"`
+	Command string    `json:"cmd,omitempty"`
+	Message string    `json:"msg"`
+}
+
+// LogLevels contains the names of log levels
+var LogLevels = []string{``, `ERROR`, `WARN`, `FORM`, `INFO`, `DEBUG`}
+
+// String returns the entry in the text format of the log
+func (entry *LogEntry) String() string {
+	return fmt.Sprintf("[%s] %s %s", entry.Level, entry.Time.Format(`2006/01/02 15:04:05`),
+		entry.Message)
+}
+
+// JSON returns the entry as a line of JSON-lines log
+func (entry *LogEntry) JSON() string {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return entry.String()
+	}
+	return string(data)
+}
+
+// ParseLogLine converts the line of the log file into the entry. The text lines contain the level,
+// the time and the message, the JSON lines contain all fields.
+func ParseLogLine(line string) (entry LogEntry, ok bool) {
+	if strings.HasPrefix(line, `{`) {
+		ok = json.Unmarshal([]byte(line), &entry) == nil
+		return
+	}
+	if !strings.HasPrefix(line, `[`) {
+		return
+	}
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return
+	}
+	if _, ok = Logs[line[1:end]]; !ok {
+		return
+	}
+	entry.Level = line[1:end]
+	entry.Message = strings.TrimPrefix(line[end+1:], ` `)
+	if len(entry.Message) >= 19 {
+		if t, err := time.ParseInLocation(`2006/01/02 15:04:05`, entry.Message[:19],
+			time.Local); err == nil {
+			entry.Time = t
+			entry.Message = strings.TrimPrefix(entry.Message[19:], ` `)
+		}
+	}
+	return
+}
+
+// FilterLog returns the log in the text format. The entries are filtered by the maximum level and
+// by the command if they are not empty. The lines of multi-line messages follow their entry.
+func FilterLog(input string, level int, cmd string) string {
+	var (
+		out  []string
+		skip bool
+	)
+	input = strings.TrimRight(input, "\r\n")
+	if len(input) == 0 {
+		return ``
+	}
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimRight(line, "\r")
+		entry, ok := ParseLogLine(line)
+		if !ok {
+			if !skip {
+				out = append(out, line)
+			}
+			continue
+		}
+		skip = (level > 0 && Logs[entry.Level] > level) || (len(cmd) > 0 && entry.Command != cmd)
+		if !skip {
+			if strings.HasPrefix(line, `{`) {
+				line = entry.String()
+			}
+			out = append(out, line)
+		}
+	}
+	if len(out) == 0 {
+		return ``
+	}
+	return strings.Join(out, "\n") + "\n"
+}
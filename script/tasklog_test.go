@@ -0,0 +1,76 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package script
+
+import (
+	"testing"
+)
+
+func TestFilterLog(t *testing.T) {
+	const (
+		textLog = "[INFO] 2021/03/15 10:20:30 started\n" +
+			"[ERROR] 2021/03/15 10:20:31 cannot open\n" +
+			"  file.txt\n" +
+			"[DEBUG] 2021/03/15 10:20:32 details\n" +
+			"  of the step\n" +
+			"[WARN] 2021/03/15 10:20:33 slow\r\n"
+		jsonLog = `{"time":"2021-03-15T10:20:30+03:00","level":"INFO","ref":"a","cmd":"Copy files","msg":"copying"}
+{"time":"2021-03-15T10:20:31+03:00","level":"ERROR","ref":"b","cmd":"Run","msg":"exit 1"}
+the output of the command
+{"time":"2021-03-15T10:20:32+03:00","level":"DEBUG","ref":"c","cmd":"Copy files","msg":"done"}
+`
+	)
+	for _, test := range []struct {
+		input string
+		level int
+		cmd   string
+		want  string
+	}{
+		{``, 0, ``, ``},
+		{textLog, 0, ``, "[INFO] 2021/03/15 10:20:30 started\n" +
+			"[ERROR] 2021/03/15 10:20:31 cannot open\n  file.txt\n" +
+			"[DEBUG] 2021/03/15 10:20:32 details\n  of the step\n" +
+			"[WARN] 2021/03/15 10:20:33 slow\n"},
+		{textLog, LOG_ERROR, ``, "[ERROR] 2021/03/15 10:20:31 cannot open\n  file.txt\n"},
+		{textLog, LOG_INFO, ``, "[INFO] 2021/03/15 10:20:30 started\n" +
+			"[ERROR] 2021/03/15 10:20:31 cannot open\n  file.txt\n" +
+			"[WARN] 2021/03/15 10:20:33 slow\n"},
+		{textLog, 0, `Run`, ``},
+		{jsonLog, 0, ``, "[INFO] 2021/03/15 10:20:30 copying\n" +
+			"[ERROR] 2021/03/15 10:20:31 exit 1\nthe output of the command\n" +
+			"[DEBUG] 2021/03/15 10:20:32 done\n"},
+		{jsonLog, LOG_WARN, ``, "[ERROR] 2021/03/15 10:20:31 exit 1\nthe output of the command\n"},
+		{jsonLog, 0, `Copy files`, "[INFO] 2021/03/15 10:20:30 copying\n" +
+			"[DEBUG] 2021/03/15 10:20:32 done\n"},
+		{jsonLog, LOG_INFO, `Copy files`, "[INFO] 2021/03/15 10:20:30 copying\n"},
+		{jsonLog, LOG_ERROR, `Copy files`, ``},
+	} {
+		if got := FilterLog(test.input, test.level, test.cmd); got != test.want {
+			t.Errorf("level %d cmd %q:\n%q\n!=\n%q", test.level, test.cmd, got, test.want)
+		}
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	for _, test := range []struct {
+		line    string
+		ok      bool
+		level   string
+		message string
+	}{
+		{`[INFO] 2021/03/15 10:20:30 started`, true, `INFO`, `started`},
+		{`[FORM] {"a":1}`, true, `FORM`, `{"a":1}`},
+		{`[NOTE] 2021/03/15 10:20:30 started`, false, ``, ``},
+		{`[INFO 2021/03/15 10:20:30 started`, false, ``, ``},
+		{`plain text`, false, ``, ``},
+		{`{"level":"WARN","msg":"slow"}`, true, `WARN`, `slow`},
+		{`{"level":`, false, ``, ``},
+	} {
+		entry, ok := ParseLogLine(test.line)
+		if ok != test.ok || (ok && (entry.Level != test.level || entry.Message != test.message)) {
+			t.Errorf(`%q: %v %+v`, test.line, ok, entry)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package script
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -205,6 +206,13 @@ func getRawVarObj(shift int, name string) (*core.Obj, error) {
 }
 
 func ResultVarObj(name string, value *core.Obj) error {
+	if IsEntry() == 1 {
+		data, err := json.Marshal(ObjToIface(value))
+		if err != nil {
+			return err
+		}
+		return ResultVar(name, string(data))
+	}
 	return setRawVarObj(1, name, value)
 }
 
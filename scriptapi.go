@@ -41,10 +41,11 @@ type ScriptResponse struct {
 }
 
 type ListResponse struct {
-	Map   map[string]ScriptItem `json:"map,omitempty"`
-	List  []ScriptItem          `json:"list,omitempty"`
-	Cache int32                 `json:"cache"`
-	Error string                `json:"error,omitempty"`
+	Map    map[string]ScriptItem `json:"map,omitempty"`
+	List   []ScriptItem          `json:"list,omitempty"`
+	Health map[string]string     `json:"health,omitempty"` // the health of the recently run scripts
+	Cache  int32                 `json:"cache"`
+	Error  string                `json:"error,omitempty"`
 }
 
 var (
@@ -179,12 +180,13 @@ func ScriptToItem(c echo.Context, script *Script) ScriptItem {
 }
 
 func listScriptHandle(c echo.Context) error {
+	user := c.(*Auth).User
 	resp := &ListResponse{
-		Cache: hotVersion,
+		Health: scriptsHealth(user.RoleID),
+		Cache:  hotVersion,
 	}
 
 	if c.QueryParam(`cache`) != fmt.Sprint(hotVersion) {
-		user := c.(*Auth).User
 		list := make(map[string]ScriptItem)
 		for _, item := range scripts {
 			if ScriptAccess(item.Settings.Name, item.Settings.Path, user.RoleID) == nil {
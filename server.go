@@ -270,6 +270,15 @@ func RunServer(options lib.HTTPConfig) *echo.Echo {
 
 	e.GET("/", indexHandle)
 	e.GET("/ping", pingHandle)
+	if !IsScript {
+		e.GET("/metrics", metricsHandle)
+		e.POST("/agent/register", agentHandle(agentRegisterHandle))
+		e.POST("/agent/upload/:id", agentHandle(agentUploadHandle))
+		e.POST("/agent/taskstatus", agentHandle(taskStatusHandle))
+		e.POST("/agent/notification", agentHandle(notificationHandle))
+		e.POST("/agent/runscript", agentHandle(runScriptHandle))
+		e.POST("/agent/extqueue", agentHandle(extQueueHandle))
+	}
 
 	e.GET("/js/*", fileHandle)
 	e.GET("/css/*", fileHandle)
@@ -281,11 +290,17 @@ func RunServer(options lib.HTTPConfig) *echo.Echo {
 		e.GET("/ws", wsTaskHandle) // +
 		e.GET("/sys", sysHandle)   //
 		//		e.GET("/info", infoHandle)    // +
-		e.POST("/stdin", stdinHandle) // +
-		e.POST("/form", formHandle)   // +
+		e.GET("/earlier", earlierHandle)       // +
+		e.POST("/stdin", stdinHandle)          // +
+		e.POST("/form", formHandle)            // +
+		e.GET("/signedform", signedFormHandle) // +
+		e.POST("/signedform", formHandle)      // +
 	} else {
 		e.GET("/ws", wsMainHandle)
 		e.GET("/task/:id", showTaskHandle)         // +
+		e.Any("/task/:id/*", proxyTaskHandle)      // +
+		e.GET("/form/:token", formPageHandle)      // +
+		e.Any("/form/:token/data", formDataHandle) // +
 		e.GET("/api/compile", compileHandle)       // +
 		e.GET("/api/exit", exitHandle)             // +
 		e.GET("/api/export", exportHandle)         // +
@@ -302,8 +317,19 @@ func RunServer(options lib.HTTPConfig) *echo.Echo {
 		e.GET("/api/pkginstall/:name", packageInstallHandle)     // +
 		e.GET("/api/pkguninstall/:name", packageUninstallHandle) // +
 		e.GET("/api/tasks", tasksHandle)                         // +
+		e.GET("/api/tasks/:id/stream", streamTaskHandle)         // +
+		e.GET("/api/scripts/:name/stats", scriptStatsHandle)     // +
+		e.GET("/api/tasks/:id/inputs", taskInputsHandle)         // +
+		e.GET("/api/tasks/:id/rerun", rerunTaskHandle)           // +
+		e.GET("/api/tasks/:id/timeline", timelineHandle)         // +
+		e.GET("/api/search", searchTasksHandle)                  // +
+		e.GET("/api/artifact/:id/:index", artifactHandle)        // +
 		e.GET("/api/timers", timersHandle)                       // +
 		e.GET("/api/events", eventsHandle)                       // +
+		e.GET("/api/triggers", triggersHandle)                   // +
+		e.GET("/api/webhooks", webhooksHandle)                   // +
+		e.GET("/api/deliveries", deliveriesHandle)               // +
+		e.GET("/api/agents", agentsHandle)                       // +
 		e.GET("/api/prosettings", proSettingsHandle)             // +
 		e.GET("/api/randid", randidHandle)                       // +
 		e.GET("/api/remove/:id", removeTaskHandle)               // +
@@ -311,8 +337,14 @@ func RunServer(options lib.HTTPConfig) *echo.Echo {
 		e.GET("/api/removenfy/:id", removeNfyHandle)             // +
 		e.GET("/api/removetimer/:id", removeTimerHandle)         // +
 		e.GET("/api/removeevent/:id", removeEventHandle)         // +
+		e.GET("/api/removetrigger/:id", removeTriggerHandle)     // +
+		e.GET("/api/removewebhook/:id", removeWebhookHandle)     // +
+		e.GET("/api/removeagent/:name", removeAgentHandle)       // +
+		e.GET("/api/cancelrun/:id", cancelRunHandle)             // +
 		e.GET("/api/sys", sysTaskHandle)                         //
 		e.GET("/api/settings", settingsHandle)                   // +
+		e.GET("/api/retention", retentionHandle)                 // +
+		e.GET("/api/retention/dryrun", purgeHandle)              // +
 		e.GET("/api/latest", latestVerHandle)                    //
 		e.GET("/api/trial/:id", trialHandle)                     // +
 		e.GET("/api/browsers", browsersHandle)                   // +
@@ -323,6 +355,7 @@ func RunServer(options lib.HTTPConfig) *echo.Echo {
 		e.POST("/api/browserext", browserExtHandle)
 		e.POST("/api/savebrowser", saveBrowserHandle) // +
 		e.POST("/api/install", installHandle)         // +
+		e.POST("/api/retention", saveRetentionHandle) // +
 		e.POST("/api/login", loginHandle)
 		e.POST("/api/script", saveScriptHandle)   // +
 		e.POST("/api/delete", deleteScriptHandle) // +
@@ -334,21 +367,31 @@ func RunServer(options lib.HTTPConfig) *echo.Echo {
 		e.POST("/api/setpsw", setPasswordHandle)    //
 		e.POST("/api/timer", saveTimerHandle)       // +
 		e.POST("/api/saveevent", saveEventHandle)   // +
+		e.POST("/api/trigger", saveTriggerHandle)   // +
+		e.POST("/api/webhook", saveWebhookHandle)   // +
 		e.POST("/api/event", eventHandle)           // +
 		e.POST("/api/favs", saveFavsHandle)
 		e.POST("/api/feedback", feedbackHandle) // +
 		ProApi(e)
 	}
 	RunLocalServer(options.LocalPort)
+	if IsScript {
+		// the web-server of the task is available only through the main web-server
+		ln, err := listenTask(scriptTask.Header.TaskID)
+		if err != nil {
+			golog.Fatal(err)
+		}
+		e.Listener = ln
+	}
 	go func() {
 		if IsScript {
 			e.Logger.SetOutput(io.Discard)
-		}
-		if lib.IsPrivateHost(options.Host) {
+			if err := e.Start(``); err != nil && !isShutdown {
+				setStatus(TaskFailed, err)
+				golog.Fatal(err)
+			}
+		} else if lib.IsPrivateHost(options.Host) {
 			if err := e.Start(fmt.Sprintf(":%d", options.Port)); err != nil && !isShutdown {
-				if IsScript {
-					setStatus(TaskFailed, err)
-				}
 				if pingHost(options.Port) {
 					lib.Open(fmt.Sprintf("http://%s:%d", Localhost, options.Port))
 				}
@@ -356,15 +399,17 @@ func RunServer(options lib.HTTPConfig) *echo.Echo {
 			}
 		} else {
 			if err := e.StartTLS(fmt.Sprintf(":%d", options.Port), options.Cert, options.Priv); err != nil && !isShutdown {
-				if IsScript {
-					setStatus(TaskFailed, err)
-				}
 				golog.Fatal(err)
 			}
 		}
 	}()
 	if options.Open {
 		go func() {
+			if IsScript {
+				lib.Open(fmt.Sprintf("http://%s:%d%s", Localhost, scriptTask.Header.URLPort,
+					taskURL(scriptTask.Header.TaskID)))
+				return
+			}
 			for !pingHost(options.Port) {
 				time.Sleep(100 * time.Millisecond)
 			}
@@ -52,6 +52,22 @@ func saveSettingsHandle(c echo.Context) error {
 		if options.Common.MaxTasks <= 0 {
 			options.Common.MaxTasks = DefMaxTasks
 		}
+		if options.Common.TaskTimeout < 0 {
+			options.Common.TaskTimeout = 0
+		}
+		if options.Common.WaitTimeout < 0 {
+			options.Common.WaitTimeout = 0
+		}
+		if options.Common.RestartLimit <= 0 {
+			options.Common.RestartLimit = DefRestartLimit
+		}
+		if options.Common.MaxRunning < 0 {
+			options.Common.MaxRunning = 0
+		}
+		if err = validateRetention(options.Common.Retention); err != nil {
+			return jsonError(c, err)
+		}
+		maxRunning := storage.Settings.MaxRunning
 		storage.Settings = options.Common
 		for key, val := range storage.Settings.Constants {
 			storage.Settings.Constants[key] = strings.TrimSpace(val)
@@ -62,6 +78,10 @@ func saveSettingsHandle(c echo.Context) error {
 		if isTray && !hideTray && storage.Settings.HideTray {
 			HideTray()
 		}
+		if maxRunning > 0 && (storage.Settings.MaxRunning == 0 ||
+			storage.Settings.MaxRunning > maxRunning) {
+			go CheckRunQueue()
+		}
 	}
 	userSets := userSettings[user.ID]
 	userSets.Lang = options.User.Lang
@@ -46,6 +46,9 @@ func showTaskHandle(c echo.Context) error {
 	if ptask, user, err = showTaskAccess(c, c.Param(`id`)); err != nil {
 		return err
 	}
+	if ptask.Status < TaskFinished {
+		return c.Redirect(http.StatusFound, taskURL(ptask.ID))
+	}
 	if item := getScript(ptask.Name); item != nil {
 		c.Set(`Title`, ScriptLang(item, GetLangCode(user), item.Settings.Title))
 	} else {
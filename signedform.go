@@ -0,0 +1,116 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"eonza/script"
+	"eonza/users"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+type SignedFormResponse struct {
+	FormID  uint32 `json:"formid"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	Ref     string `json:"ref"`
+	Remain  int    `json:"remain,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// parseFormToken checks the signature and the expiration of the link to the form
+func parseFormToken(token, claimKey string) (*script.FormClaims, error) {
+	claims := &script.FormClaims{}
+	tok, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf(`unexpected signing method %v`, token.Header[`alg`])
+		}
+		return script.FormKey(claimKey), nil
+	})
+	if err != nil || !tok.Valid {
+		return nil, fmt.Errorf(`the link is invalid or has expired`)
+	}
+	return claims, nil
+}
+
+// waitingForm returns the form of the signed link if it is waiting for the answer
+func waitingForm(token string) (*script.FormClaims, error) {
+	claims, err := parseFormToken(token, scriptTask.Header.ClaimKey)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TaskID != task.ID {
+		return nil, fmt.Errorf(`wrong task id`)
+	}
+	if len(formData) == 0 || formData[0].ID != claims.FormID {
+		return nil, fmt.Errorf(`the form has already been filled out`)
+	}
+	return claims, nil
+}
+
+// signedFormHandle returns the form of the signed link for the standalone page
+func signedFormHandle(c echo.Context) error {
+	if _, err := waitingForm(c.QueryParam(`token`)); err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusOK, &SignedFormResponse{
+		FormID:  formData[0].ID,
+		Title:   scriptTask.Header.Title,
+		Message: formData[0].Data,
+		Ref:     formData[0].Ref,
+		Remain:  formRemain(),
+	})
+}
+
+// formTokenAccess checks the signed link to the form and the role of the user. The link is signed
+// by the key of its task.
+func formTokenAccess(c echo.Context) (*Task, error) {
+	unverified := &script.FormClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(c.Param(`token`), unverified); err != nil {
+		return nil, fmt.Errorf(`the link is invalid or has expired`)
+	}
+	ptask := tasks[unverified.TaskID]
+	if ptask == nil || ptask.Status >= TaskFinished {
+		return nil, fmt.Errorf(`task %d has not been found`, unverified.TaskID)
+	}
+	claims, err := parseFormToken(c.Param(`token`), taskClaimKey(ptask))
+	if err != nil {
+		return nil, err
+	}
+	if claims.TaskID != ptask.ID {
+		return nil, fmt.Errorf(`wrong task id`)
+	}
+	user := c.(*Auth).User
+	if len(claims.Role) > 0 && user.RoleID != users.XAdminID {
+		if role, ok := GetRole(user.RoleID); !ok || role.Name != claims.Role {
+			return nil, fmt.Errorf(`Access denied`)
+		}
+	}
+	return ptask, nil
+}
+
+// formPageHandle shows the standalone page of the signed form
+func formPageHandle(c echo.Context) error {
+	if _, err := formTokenAccess(c); err != nil {
+		return jsonError(c, err)
+	}
+	c.Set(`tpl`, `form`)
+	return indexHandle(c)
+}
+
+// formDataHandle passes the requests of the standalone page to the task web-server
+func formDataHandle(c echo.Context) error {
+	ptask, err := formTokenAccess(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	c.Request().URL.RawQuery = fmt.Sprintf(`taskid=%d&token=%s`, ptask.ID,
+		url.QueryEscape(c.Param(`token`)))
+	return proxyTask(c, ptask, `signedform`)
+}
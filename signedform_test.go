@@ -0,0 +1,119 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"eonza/script"
+	"eonza/users"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+// testFormToken returns the signed link token of the form
+func testFormToken(t *testing.T, taskID uint32, role, claimKey string, expire time.Duration) string {
+	claims := &script.FormClaims{
+		TaskID: taskID,
+		FormID: 7,
+		Role:   role,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(expire).Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(
+		script.FormKey(claimKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestParseFormToken(t *testing.T) {
+	const key = `taskkey`
+	userToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &script.FormClaims{
+		TaskID: 1}).SignedString([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		token string
+		err   bool
+	}{
+		{`valid`, testFormToken(t, 1, ``, key, time.Hour), false},
+		{`valid with role`, testFormToken(t, 1, `ops`, key, time.Minute), false},
+		{`expired`, testFormToken(t, 1, ``, key, -time.Minute), true},
+		{`another key`, testFormToken(t, 1, ``, `otherkey`, time.Hour), true},
+		{`user key`, userToken, true},
+		{`garbage`, `abc.def.ghi`, true},
+		{`empty`, ``, true},
+	} {
+		claims, err := parseFormToken(test.token, key)
+		if (err != nil) != test.err {
+			t.Errorf(`%s: error %v`, test.name, err)
+		} else if err == nil && (claims.TaskID != 1 || claims.FormID != 7) {
+			t.Errorf(`%s: wrong claims %+v`, test.name, claims)
+		}
+	}
+}
+
+func TestFormTokenAccess(t *testing.T) {
+	const (
+		opsID = 5
+		devID = 6
+	)
+	prevKey, prevRoles := cfg.HTTP.JWTKey, proStorage.Roles
+	defer func() {
+		cfg.HTTP.JWTKey, proStorage.Roles = prevKey, prevRoles
+	}()
+	cfg.HTTP.JWTKey = `mainkey`
+	mainKey := cfg.HTTP.JWTKey + sessionKey
+	proStorage.Roles = map[uint32]users.Role{
+		opsID: {ID: opsID, Name: `ops`},
+		devID: {ID: devID, Name: `dev`},
+	}
+	setTestTasks(
+		&Task{ID: 1, Name: `approve`, Status: TaskWaiting},
+		&Task{ID: 2, Name: `approve`, Status: TaskFinished},
+		&Task{ID: 3, Name: `approve`, Status: TaskWaiting, Agent: `ag1`, claimKey: `agentkey`},
+	)
+	e := echo.New()
+	for _, test := range []struct {
+		name   string
+		token  string
+		roleID uint32
+		want   uint32
+	}{
+		{`any role`, testFormToken(t, 1, ``, mainKey, time.Hour), devID, 1},
+		{`same role`, testFormToken(t, 1, `ops`, mainKey, time.Hour), opsID, 1},
+		{`other role`, testFormToken(t, 1, `ops`, mainKey, time.Hour), devID, 0},
+		{`admin`, testFormToken(t, 1, `ops`, mainKey, time.Hour), users.XAdminID, 1},
+		{`unknown role`, testFormToken(t, 1, `ops`, mainKey, time.Hour), 100, 0},
+		{`expired`, testFormToken(t, 1, ``, mainKey, -time.Second), opsID, 0},
+		{`finished task`, testFormToken(t, 2, ``, mainKey, time.Hour), opsID, 0},
+		{`unknown task`, testFormToken(t, 4, ``, mainKey, time.Hour), opsID, 0},
+		{`agent task`, testFormToken(t, 3, ``, `agentkey`, time.Hour), opsID, 3},
+		{`agent task with main key`, testFormToken(t, 3, ``, mainKey, time.Hour), opsID, 0},
+		{`task with agent key`, testFormToken(t, 1, ``, `agentkey`, time.Hour), opsID, 0},
+	} {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, `/form/`, nil),
+			httptest.NewRecorder())
+		c.SetParamNames(`token`)
+		c.SetParamValues(test.token)
+		ptask, err := formTokenAccess(&Auth{Context: c, User: &users.User{ID: 2,
+			RoleID: test.roleID}})
+		var got uint32
+		if err == nil {
+			got = ptask.ID
+		}
+		if got != test.want {
+			t.Errorf(`%s: %d != %d (%v)`, test.name, got, test.want, err)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"eonza/lib"
+	"eonza/users"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// DefStatsDays is the default window of the script statistics in days
+	DefStatsDays = 7
+	// StatsFailures is the maximum number of the failure messages in the statistics
+	StatsFailures = 5
+)
+
+const (
+	HealthOK      = `ok`      // the success rate is at least 90%
+	HealthWarning = `warning` // the success rate is at least 50%
+	HealthFailing = `failing`
+)
+
+type FailureMessage struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// ScriptStats contains the aggregate statistics of the script runs
+type ScriptStats struct {
+	Name        string           `json:"name"`
+	Days        int              `json:"days"` // the window in days, 0 - the whole task history
+	Runs        int              `json:"runs"`
+	Running     int              `json:"running"`
+	Finished    int              `json:"finished"`
+	Failed      int              `json:"failed"`
+	Terminated  int              `json:"terminated"`
+	Crashed     int              `json:"crashed"`
+	Timeout     int              `json:"timeout"`
+	SuccessRate float64          `json:"successrate"` // the percent of finished tasks among completed
+	P50         int64            `json:"p50"`         // the median duration in seconds
+	P95         int64            `json:"p95"`
+	Max         int64            `json:"max"`
+	LastSuccess string           `json:"lastsuccess,omitempty"`
+	LastFailure string           `json:"lastfailure,omitempty"`
+	Failures    []FailureMessage `json:"failures,omitempty"`
+	Health      string           `json:"health,omitempty"`
+}
+
+type ScriptStatsResponse struct {
+	Stats *ScriptStats `json:"stats,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+var (
+	// healthCache contains the health of the scripts by their identifier names
+	healthCache = make(map[string]string)
+)
+
+// statsBuilder accumulates the finished tasks of the script
+type statsBuilder struct {
+	stats       ScriptStats
+	durations   []int64
+	failures    map[string]int
+	lastSuccess int64
+	lastFailure int64
+}
+
+func (builder *statsBuilder) add(ptask *Task) {
+	stats := &builder.stats
+	stats.Runs++
+	switch ptask.Status {
+	case TaskFinished:
+		stats.Finished++
+		if ptask.FinishTime > builder.lastSuccess {
+			builder.lastSuccess = ptask.FinishTime
+		}
+	case TaskFailed:
+		stats.Failed++
+	case TaskTerminated:
+		stats.Terminated++
+	case TaskCrashed:
+		stats.Crashed++
+	case TaskTimeout:
+		stats.Timeout++
+	default:
+		stats.Running++
+		return
+	}
+	if ptask.FinishTime >= ptask.StartTime {
+		builder.durations = append(builder.durations, ptask.FinishTime-ptask.StartTime)
+	}
+	if ptask.Status == TaskFinished {
+		return
+	}
+	if ptask.FinishTime > builder.lastFailure {
+		builder.lastFailure = ptask.FinishTime
+	}
+	if len(ptask.Message) > 0 {
+		builder.failures[ptask.Message]++
+	}
+}
+
+func percentile(durations []int64, percent int) int64 {
+	if len(durations) == 0 {
+		return 0
+	}
+	return durations[(len(durations)-1)*percent/100]
+}
+
+func (builder *statsBuilder) result() *ScriptStats {
+	stats := &builder.stats
+	if completed := stats.Runs - stats.Running; completed > 0 {
+		stats.SuccessRate = float64(stats.Finished*10000/completed) / 100
+		switch {
+		case stats.SuccessRate >= 90:
+			stats.Health = HealthOK
+		case stats.SuccessRate >= 50:
+			stats.Health = HealthWarning
+		default:
+			stats.Health = HealthFailing
+		}
+	}
+	sort.Slice(builder.durations, func(i, j int) bool {
+		return builder.durations[i] < builder.durations[j]
+	})
+	stats.P50 = percentile(builder.durations, 50)
+	stats.P95 = percentile(builder.durations, 95)
+	stats.Max = percentile(builder.durations, 100)
+	if builder.lastSuccess > 0 {
+		stats.LastSuccess = time.Unix(builder.lastSuccess, 0).Format(TimeFormat)
+	}
+	if builder.lastFailure > 0 {
+		stats.LastFailure = time.Unix(builder.lastFailure, 0).Format(TimeFormat)
+	}
+	for msg, count := range builder.failures {
+		stats.Failures = append(stats.Failures, FailureMessage{Message: msg, Count: count})
+	}
+	sort.Slice(stats.Failures, func(i, j int) bool {
+		if stats.Failures[i].Count == stats.Failures[j].Count {
+			return stats.Failures[i].Message < stats.Failures[j].Message
+		}
+		return stats.Failures[i].Count > stats.Failures[j].Count
+	})
+	if len(stats.Failures) > StatsFailures {
+		stats.Failures = stats.Failures[:StatsFailures]
+	}
+	return stats
+}
+
+// collectStats returns the statistics of the script from the task history for the last days.
+// If visible is not nil then only the tasks accepted by it are counted.
+func collectStats(name string, days int, visible func(*Task) bool) *ScriptStats {
+	var from int64
+	if days > 0 {
+		from = time.Now().AddDate(0, 0, -days).Unix()
+	}
+	builder := statsBuilder{
+		stats:    ScriptStats{Name: name, Days: days},
+		failures: make(map[string]int),
+	}
+	for _, item := range tasksByName[lib.IdName(name)] {
+		if item.StartTime < from || (visible != nil && !visible(item)) {
+			continue
+		}
+		builder.add(item)
+	}
+	return builder.result()
+}
+
+// scriptHealth returns the health of the script for the last DefStatsDays. The value is cached
+// until the next task of the script finishes.
+func scriptHealth(name string) string {
+	key := lib.IdName(name)
+	health, ok := healthCache[key]
+	if !ok {
+		health = collectStats(name, DefStatsDays, nil).Health
+		healthCache[key] = health
+	}
+	return health
+}
+
+// resetHealth drops the cached health of the script
+func resetHealth(name string) {
+	delete(healthCache, lib.IdName(name))
+}
+
+// scriptsHealth returns the health of the scripts which have been run for the last DefStatsDays
+func scriptsHealth(roleid uint32) map[string]string {
+	ret := make(map[string]string)
+	for name := range tasksByName {
+		item := getScript(name)
+		if item == nil || ScriptAccess(item.Settings.Name, item.Settings.Path, roleid) != nil {
+			continue
+		}
+		if health := scriptHealth(name); len(health) > 0 {
+			ret[item.Settings.Name] = health
+		}
+	}
+	return ret
+}
+
+func scriptStatsHandle(c echo.Context) error {
+	name := c.Param(`name`)
+	item := getScript(name)
+	if item == nil {
+		return jsonError(c, fmt.Errorf(`script %s has not been found`, name))
+	}
+	user := c.(*Auth).User
+	if err := ScriptAccess(item.Settings.Name, item.Settings.Path, user.RoleID); err != nil {
+		return jsonError(c, err)
+	}
+	days := DefStatsDays
+	if val := c.QueryParam(`days`); len(val) > 0 {
+		var err error
+		if days, err = strconv.Atoi(val); err != nil || days < 0 {
+			return jsonError(c, fmt.Errorf(`invalid window '%s'`, val))
+		}
+	}
+	var taskFlag int
+	if user.RoleID != users.XAdminID {
+		if role, ok := GetRole(user.RoleID); ok {
+			taskFlag = role.Tasks
+		}
+	}
+	stats := collectStats(name, days, func(ptask *Task) bool {
+		return taskVisible(user, taskFlag, ptask)
+	})
+	return c.JSON(http.StatusOK, &ScriptStatsResponse{Stats: stats})
+}
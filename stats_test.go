@@ -0,0 +1,112 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	for _, test := range []struct {
+		durations []int64
+		percent   int
+		want      int64
+	}{
+		{nil, 50, 0},
+		{[]int64{7}, 50, 7},
+		{[]int64{7}, 95, 7},
+		{[]int64{1, 2, 3}, 50, 2},
+		{[]int64{1, 2, 3, 4}, 50, 2},
+		{[]int64{1, 2, 3, 4}, 100, 4},
+		{[]int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 95, 9},
+		{[]int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0, 1},
+	} {
+		if got := percentile(test.durations, test.percent); got != test.want {
+			t.Errorf(`%v %d: %d != %d`, test.durations, test.percent, got, test.want)
+		}
+	}
+}
+
+func TestCollectStats(t *testing.T) {
+	now := time.Now().Unix()
+	day := int64(24 * 3600)
+	list := []*Task{
+		{ID: 1, Name: `backup`, Status: TaskFinished, StartTime: now - 100, FinishTime: now - 90},
+		{ID: 2, Name: `backup`, Status: TaskFinished, StartTime: now - 200, FinishTime: now - 170},
+		{ID: 3, Name: `backup`, Status: TaskFailed, StartTime: now - 300, FinishTime: now - 280,
+			Message: `disk full`, UserID: 2},
+		{ID: 4, Name: `backup`, Status: TaskCrashed, StartTime: now - 400, FinishTime: now - 340,
+			Message: `disk full`, UserID: 2},
+		{ID: 5, Name: `backup`, Status: TaskActive, StartTime: now - 50},
+		{ID: 6, Name: `backup`, Status: TaskFinished, StartTime: now - 10*day,
+			FinishTime: now - 10*day + 500},
+		{ID: 7, Name: `report`, Status: TaskTimeout, StartTime: now - 100, FinishTime: now - 40},
+	}
+	setTestTasks(list...)
+	for _, test := range []struct {
+		name    string
+		days    int
+		visible func(*Task) bool
+		want    string
+	}{
+		{`backup`, 7, nil, `5 1 2 1 1 0 50.00 20 30 60 warning [disk full:2]`},
+		{`backup`, 0, nil, `6 1 3 1 1 0 60.00 30 60 500 warning [disk full:2]`},
+		{`backup`, 7, func(ptask *Task) bool { return ptask.UserID != 2 },
+			`3 1 2 0 0 0 100.00 10 10 30 ok []`},
+		{`backup`, 7, func(ptask *Task) bool { return ptask.UserID == 2 },
+			`2 0 0 1 1 0 0.00 20 20 60 failing [disk full:2]`},
+		{`report`, 7, nil, `1 0 0 0 0 1 0.00 60 60 60 failing []`},
+		{`unknown`, 7, nil, `0 0 0 0 0 0 0.00 0 0 0  []`},
+	} {
+		stats := collectStats(test.name, test.days, test.visible)
+		failures := make([]string, 0)
+		for _, item := range stats.Failures {
+			failures = append(failures, fmt.Sprintf(`%s:%d`, item.Message, item.Count))
+		}
+		got := fmt.Sprintf(`%d %d %d %d %d %d %.2f %d %d %d %s %v`, stats.Runs, stats.Running,
+			stats.Finished, stats.Failed+stats.Terminated, stats.Crashed, stats.Timeout,
+			stats.SuccessRate, stats.P50, stats.P95, stats.Max, stats.Health, failures)
+		if got != test.want {
+			t.Errorf(`%s %d: %s != %s`, test.name, test.days, got, test.want)
+		}
+		if stats.Name != test.name || stats.Days != test.days {
+			t.Errorf(`%s %d: wrong name or window %+v`, test.name, test.days, stats)
+		}
+	}
+}
+
+func TestScriptHealth(t *testing.T) {
+	now := time.Now().Unix()
+	healthCache = make(map[string]string)
+	setTestTasks(
+		&Task{ID: 1, Name: `backup`, Status: TaskFinished, StartTime: now - 100, FinishTime: now - 90},
+		&Task{ID: 2, Name: `backup`, Status: TaskFailed, StartTime: now - 80, FinishTime: now - 70},
+	)
+	for _, test := range []struct {
+		status int
+		reset  bool
+		want   string
+	}{
+		{0, false, HealthWarning},
+		{TaskFailed, false, HealthWarning}, // the cached value
+		{0, true, HealthFailing},
+		{TaskFinished, false, HealthFailing},
+		{TaskFinished, true, HealthWarning},
+	} {
+		if test.status != 0 {
+			id := uint32(len(tasks) + 1)
+			storeTask(&Task{ID: id, Name: `backup`, Status: test.status, StartTime: now - 60,
+				FinishTime: now - 50})
+		}
+		if test.reset {
+			resetHealth(`backup`)
+		}
+		if got := scriptHealth(`backup`); got != test.want {
+			t.Errorf(`%d %v: %s != %s`, test.status, test.reset, got, test.want)
+		}
+	}
+}
@@ -22,8 +22,9 @@ const (
 	StorageExt = `eox`
 	TrialDays  = 30
 
-	DefMaxTasks    = 100
-	DefRemoveAfter = 14
+	DefMaxTasks     = 100
+	DefRemoveAfter  = 14
+	DefRestartLimit = 5
 )
 
 type Trial struct {
@@ -45,6 +46,12 @@ type Settings struct {
 	RemoveAfter    int               `json:"removeafter"`
 	MaxTasks       int               `json:"maxtasks"`
 	HideDupTasks   bool              `json:"hideduptasks"`
+	TaskTimeout    int               `json:"tasktimeout"`  // default maximum run duration in minutes
+	WaitTimeout    int               `json:"waittimeout"`  // default maximum form waiting in minutes
+	RestartLimit   int               `json:"restartlimit"` // maximum crashed tasks restarted at startup
+	Retention      []RetentionRule   `json:"retention"`    // per-script retention rules
+	JSONLog        bool              `json:"jsonlog"`      // structured JSON-lines task logs
+	MaxRunning     int               `json:"maxrunning"`   // maximum running tasks, 0 - unlimited
 }
 
 // Storage contains all application data
@@ -57,6 +64,9 @@ type Storage struct {
 	Scripts     map[string]*Script
 	Timers      map[uint32]*Timer
 	Events      map[string]*Event
+	Triggers    map[uint32]*Trigger
+	Webhooks    map[uint32]*Webhook
+	Agents      map[string]*AgentInfo
 	Browsers    []*Browser
 	PkgValues   map[string]map[string]interface{}
 }
@@ -65,17 +75,21 @@ var (
 	storage = Storage{
 		Version: GetVersion(),
 		Settings: Settings{
-			LogLevel:    script.LOG_INFO,
-			Constants:   make(map[string]string),
-			AutoUpdate:  `weekly`,
-			MaxTasks:    DefMaxTasks,
-			RemoveAfter: DefRemoveAfter,
+			LogLevel:     script.LOG_INFO,
+			Constants:    make(map[string]string),
+			AutoUpdate:   `weekly`,
+			MaxTasks:     DefMaxTasks,
+			RemoveAfter:  DefRemoveAfter,
+			RestartLimit: DefRestartLimit,
 		},
 		Users:     make(map[uint32]*User),
 		Scripts:   make(map[string]*Script),
 		Timers:    make(map[uint32]*Timer),
 		Browsers:  make([]*Browser, 0),
 		Events:    make(map[string]*Event),
+		Triggers:  make(map[uint32]*Trigger),
+		Webhooks:  make(map[uint32]*Webhook),
+		Agents:    make(map[string]*AgentInfo),
 		PkgValues: make(map[string]map[string]interface{}),
 	}
 	mutex = &sync.Mutex{}
@@ -115,6 +129,15 @@ func LoadStorage(psw string) {
 	if err := zr.Close(); err != nil {
 		golog.Fatal(err)
 	}
+	if storage.Triggers == nil {
+		storage.Triggers = make(map[uint32]*Trigger)
+	}
+	if storage.Webhooks == nil {
+		storage.Webhooks = make(map[uint32]*Webhook)
+	}
+	if storage.Agents == nil {
+		storage.Agents = make(map[string]*AgentInfo)
+	}
 	if storage.Trial.Mode != TrialDisabled && storage.Trial.Count > TrialDays {
 		storage.Trial.Mode = TrialDisabled
 	}
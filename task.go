@@ -12,12 +12,14 @@ import (
 	"eonza/users"
 	"fmt"
 	"html"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gentee/gentee"
@@ -38,6 +40,7 @@ const (
 	WcProgress        // progress bar
 	WcNotify          // notification
 	WcReport          // report
+	WcEarlier         // the earlier output has been removed from memory
 )
 
 const (
@@ -46,6 +49,9 @@ const (
 	TExtLog
 	TExtSrc
 	TExtReport
+	TExtArtifact
+	TExtInputs
+	TExtTimeline
 )
 
 type CheckListForm struct {
@@ -77,6 +83,8 @@ type WsCmd struct {
 	Time    string `json:"finish,omitempty"`
 	Task    *Task  `json:"task,omitempty"`
 	Ref     string `json:"ref,omitempty"`
+	Remain  int    `json:"remain,omitempty"` // the remaining time of the form in seconds
+	Link    string `json:"link,omitempty"`   // the signed URL of the form
 }
 
 type StdinForm struct {
@@ -88,7 +96,7 @@ var (
 	prevStatus int
 	upgrader   websocket.Upgrader
 	wsChan     chan WsCmd
-	TaskExt    = []string{"trace", "out", "log", "g", "eor"}
+	TaskExt    = []string{"trace", "out", "log", "g", "eor", "eoa", "eoi", "eot"}
 
 	stdoutBuf  []string
 	logoutBuf  []string
@@ -106,7 +114,7 @@ var (
 
 	chStdin    chan []byte
 	chStdout   chan []byte
-	chLogout   chan string
+	chLogout   chan script.LogEntry
 	chForm     chan script.FormInfo
 	chFormNext chan bool
 	chReport   chan script.Report
@@ -114,6 +122,10 @@ var (
 	chSystem   chan int
 	chFinish   chan bool
 
+	cancelled   bool // the graceful cancel has been requested
+	finishing   bool // the final status is being set
+	finishMutex = &sync.Mutex{}
+
 	clients = make(map[uint32]WsClient)
 )
 
@@ -121,11 +133,15 @@ func closeTask() {
 	var files []string
 
 	for ; iStdout < len(stdoutBuf); iStdout++ {
-		out := lib.ClearCarriage(stdoutBuf[iStdout]) + "\r\n"
-		if _, err := outFile.Write([]byte(out)); err != nil {
+		out := lib.ClearCarriage(stdoutBuf[iStdout])
+		if len(out) > 0 {
+			recordEvent(WsCmd{Cmd: WcStdout, Message: out + "\n"})
+		}
+		if _, err := outFile.Write([]byte(outLine(out) + "\r\n")); err != nil {
 			golog.Error(err)
 		}
 	}
+	closeTimeline()
 	cmdFile.Close()
 	outFile.Close()
 	logScript.Close()
@@ -139,6 +155,9 @@ func closeTask() {
 		}
 		files = append(files, fname)
 	}
+	for _, item := range script.Artifacts() {
+		files = append(files, filepath.Join(scriptTask.Header.LogDir, item.File))
+	}
 	output := filepath.Join(scriptTask.Header.LogDir, fmt.Sprintf("%08x.zip", task.ID))
 
 	if err := lib.ZipFiles(output, files); err != nil {
@@ -151,6 +170,14 @@ func closeTask() {
 	es.ClosePkgs()
 }
 
+// outLine returns the console line as it is written to the .out file
+func outLine(line string) string {
+	if scriptTask.Header.StripANSI {
+		return lib.StripANSI(line)
+	}
+	return line
+}
+
 func sendForm(client WsClient) error {
 	if len(formData) == 0 {
 		return nil
@@ -161,6 +188,8 @@ func sendForm(client WsClient) error {
 		Ref:     formData[0].Ref,
 		Message: formData[0].Data,
 		Status:  int(formData[0].ID),
+		Remain:  formRemain(),
+		Link:    formData[0].Link,
 	})
 }
 
@@ -232,8 +261,6 @@ func initTask() script.Settings {
 		Status:    TaskActive,
 		Name:      scriptTask.Header.Name,
 		StartTime: time.Now().Unix(),
-		Port:      scriptTask.Header.HTTP.Port,
-		LocalPort: scriptTask.Header.HTTP.LocalPort,
 	}
 
 	createFile := func(ext string) *os.File {
@@ -248,6 +275,8 @@ func initTask() script.Settings {
 	cmdFile = createFile(`trace`)
 	outFile = createFile(`out`)
 	logScript = createFile(`log`)
+	timelineFile = createFile(TaskExt[TExtTimeline])
+	timelineStart = time.Now()
 	golog.SetOutput(logScript)
 
 	if _, err = cmdFile.Write([]byte(task.Head())); err != nil {
@@ -265,13 +294,14 @@ func initTask() script.Settings {
 		}
 		srcFile.Close()
 	}
+	initInputs()
 	console = os.Stdout
 	upgrader = websocket.Upgrader{}
 	wsChan = make(chan WsCmd)
 
 	chStdin = make(chan []byte)
 	chStdout = make(chan []byte)
-	chLogout = make(chan string)
+	chLogout = make(chan script.LogEntry)
 	chForm = make(chan script.FormInfo)
 	chFormNext = make(chan bool)
 	chReport = make(chan script.Report)
@@ -280,6 +310,7 @@ func initTask() script.Settings {
 	chFinish = make(chan bool)
 	stdoutBuf = []string{``}
 	logoutBuf = make([]string, 0, 32)
+	logoutPos = make([]int64, 0, 32)
 
 	go func() {
 		var out []byte
@@ -297,12 +328,20 @@ func initTask() script.Settings {
 				stdoutBuf[off] = lib.ClearCarriage(stdoutBuf[off])
 				stdoutBuf = append(stdoutBuf, lines[len(lines)-1])
 			}
+			var lineout string
 			for i := off; i < len(stdoutBuf)-1; i++ {
-				if _, err := outFile.Write([]byte(stdoutBuf[i] + "\r\n")); err != nil {
+				if _, err := outFile.Write([]byte(outLine(stdoutBuf[i]) + "\r\n")); err != nil {
 					golog.Error(err)
 				}
+				lineout += stdoutBuf[i] + "\n"
+			}
+			if len(lineout) > 0 {
+				recordEvent(WsCmd{Cmd: WcStdout, Message: lineout})
 			}
 			iStdout = len(stdoutBuf) - 1
+			if len(stdoutBuf[iStdout]) > 0 {
+				recordEvent(WsCmd{Cmd: WcStdbuf, Message: lib.ClearCarriage(stdoutBuf[iStdout])})
+			}
 			for id, client := range clients {
 				if sendStdout(client) == nil {
 					client.StdoutCount = iStdout
@@ -312,20 +351,32 @@ func initTask() script.Settings {
 					delete(clients, id)
 				}
 			}
+			trimStdout()
 			mutex.Unlock()
 		}
 	}()
 
 	go func() {
-		var out string
+		var entry script.LogEntry
 		for {
-			out = <-chLogout
+			entry = <-chLogout
+			out := entry.String()
+			line := out
+			if scriptTask.Header.JSONLog {
+				line = entry.JSON()
+			}
 			mutex.Lock()
-			if _, err := logScript.Write([]byte(out + "\r\n")); err != nil {
+			pos, err := logScript.Seek(0, io.SeekCurrent)
+			if err != nil {
+				golog.Error(err)
+			}
+			if _, err := logScript.Write([]byte(line + "\r\n")); err != nil {
 				golog.Error(err)
 			}
 			logoutBuf = append(logoutBuf, out)
+			logoutPos = append(logoutPos, pos)
 			iLogout = len(logoutBuf)
+			recordEvent(WsCmd{Cmd: WcLogout, Message: html.EscapeString(out + "\n")})
 			for id, client := range clients {
 				if sendLogout(client) == nil {
 					client.LogoutCount = iLogout
@@ -335,6 +386,7 @@ func initTask() script.Settings {
 					delete(clients, id)
 				}
 			}
+			trimLogout()
 			mutex.Unlock()
 		}
 	}()
@@ -345,6 +397,7 @@ func initTask() script.Settings {
 			prog = <-chProgress
 			msg, err := ProgressToString(prog)
 			if err == nil {
+				recordEvent(WsCmd{Cmd: WcProgress, Message: msg})
 				mutex.Lock()
 				for id, client := range clients {
 					if sendProgress(client, msg) != nil {
@@ -362,13 +415,27 @@ func initTask() script.Settings {
 		for {
 			select {
 			case out = <-chForm:
+				if len(formData) == 0 && replayForm(out) {
+					out.ChResponse <- true
+					continue
+				}
 				formData = append(formData, out)
 				if len(formData) > 1 {
 					continue
 				}
+				if task.Status == TaskActive {
+					setStatus(TaskWaiting)
+				}
 			case <-chFormNext:
 			}
+			if len(formData) > 0 {
+				recordEvent(WsCmd{Cmd: WcForm, Ref: formData[0].Ref, Message: formData[0].Data,
+					Status: int(formData[0].ID)})
+			}
 			mutex.Lock()
+			if len(formData) > 0 {
+				startFormTimer(formData[0])
+			}
 			for id, client := range clients {
 				if sendForm(client) != nil {
 					client.Conn.Close()
@@ -445,7 +512,7 @@ func initTask() script.Settings {
 	(*glob)[`temppath`] = os.TempDir()
 	(*glob)[`os`] = runtime.GOOS
 	(*glob)[`isconsole`] = fmt.Sprint(scriptTask.Header.Console)
-	(*glob)[`port`] = fmt.Sprint(scriptTask.Header.HTTP.Port)
+	(*glob)[`port`] = fmt.Sprint(scriptTask.Header.URLPort)
 	(*glob)[`localport`] = fmt.Sprint(scriptTask.Header.HTTP.LocalPort)
 	(*glob)[`n`] = "\n"
 	(*glob)[`r`] = "\r"
@@ -484,7 +551,10 @@ func wsTaskHandle(c echo.Context) error {
 			UserID: user.ID,
 			RoleID: user.RoleID,
 		}
-		if err = sendStdout(client); err == nil {
+		if err = sendEarlier(client); err == nil {
+			err = sendStdout(client)
+		}
+		if err == nil {
 			client.StdoutCount = iStdout
 			if err = sendLogout(client); err == nil {
 				client.LogoutCount = iLogout
@@ -511,13 +581,19 @@ func infoHandle(c echo.Context) error {
 }
 
 func sendCmdStatus(status int, timeStamp int64, message string) {
+	var results map[string]string
+
 	taskTrace(timeStamp, status, message)
+	if status >= TaskFinished {
+		results = script.TaskResults()
+	}
 	if _, err := lib.LocalPost(scriptTask.Header.ServerPort, `api/taskstatus`,
 		TaskStatus{
 			TaskID:  task.ID,
 			Status:  status,
 			Message: message,
 			Time:    timeStamp,
+			Results: results,
 		}); err != nil {
 		golog.Error(err)
 	}
@@ -527,7 +603,9 @@ func sendCmdStatus(status int, timeStamp int64, message string) {
 		task.FinishTime = timeStamp
 		finish = time.Unix(timeStamp, 0).Format(TimeFormat)
 	}
-	wsChan <- WsCmd{TaskID: task.ID, Cmd: WcStatus, Status: status, Message: message, Time: finish}
+	cmd := WsCmd{TaskID: task.ID, Cmd: WcStatus, Status: status, Message: message, Time: finish}
+	recordEvent(cmd)
+	wsChan <- cmd
 }
 
 func taskAccess(c echo.Context) error {
@@ -545,12 +623,7 @@ func sysHandle(c echo.Context) error {
 		return jsonError(c, fmt.Errorf(`wrong task id`))
 	}
 	if cmd == gentee.SysTerminate {
-		go func() {
-			setStatus(TaskTerminated)
-			closeTask()
-			<-chFinish
-			os.Exit(1)
-		}()
+		cancelTask()
 	}
 	if cmd >= gentee.SysSuspend && cmd < gentee.SysTerminate {
 		chSystem <- int(cmd)
@@ -565,6 +638,61 @@ func sysHandle(c echo.Context) error {
 	return jsonSuccess(c)
 }
 
+// cancelTask stops the script gracefully so that it can run the finally blocks. The task is
+// terminated at once on the repeated request or if the script has not stopped during the grace
+// period.
+func cancelTask() {
+	finishMutex.Lock()
+	repeated := cancelled
+	cancelled = true
+	finishMutex.Unlock()
+	if repeated {
+		go forceTerminate()
+		return
+	}
+	select {
+	case chSystem <- gentee.SysTerminate:
+	default:
+		// the script is not running now
+	}
+	grace := time.Duration(scriptTask.Header.GracePeriod) * time.Second
+	if grace <= 0 {
+		grace = KillTimeout
+	}
+	time.AfterFunc(grace, forceTerminate)
+}
+
+// startFinish returns false if the task is already being finished
+func startFinish() bool {
+	finishMutex.Lock()
+	defer finishMutex.Unlock()
+	if finishing {
+		return false
+	}
+	finishing = true
+	return true
+}
+
+func forceTerminate() {
+	if !startFinish() {
+		return
+	}
+	setStatus(TaskTerminated)
+	closeTask()
+	<-chFinish
+	os.Exit(1)
+}
+
+// runCleanup runs the finally blocks of the script which has failed or has been cancelled
+func runCleanup(settings script.Settings, reason error) {
+	script.LogOutput(script.MainThread, script.LOG_INFO, fmt.Sprintf(`=> finally(%q)`,
+		reason.Error()))
+	script.PrepareCleanup()
+	if _, err := scriptTask.Run(settings); err != nil {
+		script.LogOutput(script.MainThread, script.LOG_ERROR, err.Error())
+	}
+}
+
 func setStatus(status int, pars ...interface{}) {
 	var message string
 	if len(pars) > 0 {
@@ -601,10 +729,17 @@ func stdinHandle(c echo.Context) error {
 
 func formHandle(c echo.Context) error {
 	var (
-		form FormResponse
-		err  error
+		form   FormResponse
+		err    error
+		claims *script.FormClaims
 	)
-	if err := taskAccess(c); err != nil {
+	// the form can be answered by other users through the signed link. The route of the signed
+	// form is available without the authorization so the link is required there.
+	if token := c.QueryParam(`token`); len(token) > 0 || c.Path() == `/signedform` {
+		if claims, err = waitingForm(token); err != nil {
+			return jsonError(c, err)
+		}
+	} else if err := taskAccess(c); err != nil {
 		return jsonError(c, err)
 	}
 	id, _ := strconv.ParseInt(c.QueryParam(`taskid`), 10, 64)
@@ -614,92 +749,126 @@ func formHandle(c echo.Context) error {
 	if err = c.Bind(&form); err != nil {
 		return jsonError(c, err)
 	}
-	if len(formData) > 0 && formData[0].ID == form.FormID {
-		var fData es.FormDataStack
-		//		var formParams []es.FormParam
-		if err = json.Unmarshal([]byte(formData[0].Data), &fData); err != nil {
-			return jsonError(c, err)
+	if claims != nil {
+		form.FormID = claims.FormID
+	}
+	if err = answerForm(&form); err != nil {
+		return jsonError(c, err)
+	}
+	return jsonSuccess(c)
+}
+
+// answerForm applies the values to the shown form and passes to the next form. mutex must be locked.
+func answerForm(form *FormResponse) error {
+	if len(formData) == 0 || formData[0].ID != form.FormID {
+		return nil
+	}
+	if err := applyForm(formData[0], form); err != nil {
+		return err
+	}
+	closeForm(true)
+	return nil
+}
+
+// closeForm removes the shown form and returns the result to the script
+func closeForm(ok bool) {
+	if formTimer != nil {
+		formTimer.Stop()
+		formTimer = nil
+	}
+	formDeadline = time.Time{}
+	if ok && len(formData) == 1 && task.Status == TaskWaiting {
+		setStatus(TaskActive)
+	}
+	formData[0].ChResponse <- ok
+	formData = formData[1:]
+	if len(formData) > 0 {
+		chFormNext <- true
+	}
+}
+
+// applyForm checks the values of the form and assigns them to the variables of the script
+func applyForm(info script.FormInfo, form *FormResponse) (err error) {
+	var fData es.FormDataStack
+	//		var formParams []es.FormParam
+	if err = json.Unmarshal([]byte(info.Data), &fData); err != nil {
+		return err
+	}
+	psw := make(map[string]es.ParamType)
+	for _, item := range fData.List {
+		var options es.ScriptOptions
+		ptype, _ := strconv.ParseInt(item.Type, 10, 62)
+		if es.ParamType(ptype) == es.PPassword {
+			psw[item.Var] = es.PPassword
 		}
-		psw := make(map[string]es.ParamType)
-		for _, item := range fData.List {
-			var options es.ScriptOptions
-			ptype, _ := strconv.ParseInt(item.Type, 10, 62)
-			if es.ParamType(ptype) == es.PPassword {
-				psw[item.Var] = es.PPassword
-			}
-			if es.ParamType(ptype) == es.PCheckList {
-				psw[item.Var] = es.PCheckList
+		if es.ParamType(ptype) == es.PCheckList {
+			psw[item.Var] = es.PCheckList
+		}
+		if len(item.Options) == 0 {
+			continue
+		}
+		switch es.ParamType(ptype) {
+		case es.PNumber, es.PSingleText, es.PTextarea, es.PPassword:
+			if err = json.Unmarshal([]byte(item.Options), &options); err != nil {
+				return err
 			}
-			if len(item.Options) == 0 {
-				continue
+			if options.Required && !form.Skip && len(fmt.Sprint(form.Values[item.Var])) == 0 {
+				return fmt.Errorf(Lang(GetLangId(nil), "errreq", item.Text))
 			}
-			switch es.ParamType(ptype) {
-			case es.PNumber, es.PSingleText, es.PTextarea, es.PPassword:
-				if err = json.Unmarshal([]byte(item.Options), &options); err != nil {
-					return jsonError(c, err)
-				}
-				if options.Required && !form.Skip && len(fmt.Sprint(form.Values[item.Var])) == 0 {
-					return jsonError(c, fmt.Errorf(Lang(GetLangId(nil), "errreq", item.Text)))
-				}
-				if strings.Contains(options.Flags, "password") {
-					psw[item.Var] = es.PPassword
-				}
+			if strings.Contains(options.Flags, "password") {
+				psw[item.Var] = es.PPassword
 			}
 		}
-		for key, val := range form.Values {
-			if psw[key] == es.PCheckList {
-				var checkList CheckListForm
-				if err := json.Unmarshal([]byte(fmt.Sprint(val)), &checkList); err != nil {
-					script.LogOutput(script.MainThread, script.LOG_ERROR, err.Error())
-				}
-				form.Values[key] = fmt.Sprint(checkList.Selected)
-				obj, err := script.GetVarObj(checkList.Var)
-				if err != nil {
-					script.LogOutput(script.MainThread, script.LOG_ERROR, err.Error())
+	}
+	recordForm(info.Ref, form, psw)
+	for key, val := range form.Values {
+		if psw[key] == es.PCheckList {
+			var checkList CheckListForm
+			if err := json.Unmarshal([]byte(fmt.Sprint(val)), &checkList); err != nil {
+				script.LogOutput(script.MainThread, script.LOG_ERROR, err.Error())
+			}
+			form.Values[key] = fmt.Sprint(checkList.Selected)
+			obj, err := script.GetVarObj(checkList.Var)
+			if err != nil {
+				script.LogOutput(script.MainThread, script.LOG_ERROR, err.Error())
+			}
+			if len(checkList.Check) == 0 {
+				newArr := core.NewArray()
+				for _, v := range checkList.Selected {
+					newArr.Data = append(newArr.Data, obj.Data.(*core.Array).Data[v])
 				}
-				if len(checkList.Check) == 0 {
-					newArr := core.NewArray()
-					for _, v := range checkList.Selected {
-						newArr.Data = append(newArr.Data, obj.Data.(*core.Array).Data[v])
+				obj.Data = newArr
+			} else {
+				data := obj.Data.(*core.Array).Data
+				for _, v := range data {
+					obj := v.(*core.Obj)
+					if vm.IsMapºObj(obj) != 0 {
+						vmap := obj.Data.(*core.Map)
+						vmap.SetIndex(checkList.Check, false)
 					}
-					obj.Data = newArr
-				} else {
-					data := obj.Data.(*core.Array).Data
-					for _, v := range data {
-						obj := v.(*core.Obj)
-						if vm.IsMapºObj(obj) != 0 {
-							vmap := obj.Data.(*core.Map)
-							vmap.SetIndex(checkList.Check, false)
-						}
+				}
+				for _, v := range checkList.Selected {
+					if len(data) <= v {
+						break
 					}
-					for _, v := range checkList.Selected {
-						if len(data) <= v {
-							break
-						}
-						obj := data[v].(*core.Obj)
-						if vm.IsMapºObj(obj) != 0 {
-							vmap := obj.Data.(*core.Map)
-							vmap.SetIndex(checkList.Check, true)
-						}
+					obj := data[v].(*core.Obj)
+					if vm.IsMapºObj(obj) != 0 {
+						vmap := obj.Data.(*core.Map)
+						vmap.SetIndex(checkList.Check, true)
 					}
 				}
-				continue
-			}
-			script.SetVar(key, fmt.Sprint(val))
-			if psw[key] == es.PPassword {
-				form.Values[key] = `***`
 			}
+			continue
 		}
-		if forLog, err := json.Marshal(form.Values); err != nil {
-			script.LogOutput(script.MainThread, script.LOG_ERROR, err.Error())
-		} else {
-			script.LogOutput(script.MainThread, script.LOG_FORM, string(forLog))
-		}
-		formData[0].ChResponse <- true
-		formData = formData[1:]
-		if len(formData) > 0 {
-			chFormNext <- true
+		script.SetVar(key, fmt.Sprint(val))
+		if psw[key] == es.PPassword {
+			form.Values[key] = MaskedValue
 		}
 	}
-	return jsonSuccess(c)
+	if forLog, err := json.Marshal(form.Values); err != nil {
+		script.LogOutput(script.MainThread, script.LOG_ERROR, err.Error())
+	} else {
+		script.LogOutput(script.MainThread, script.LOG_FORM, string(forLog))
+	}
+	return nil
 }
@@ -0,0 +1,172 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"eonza/script"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// DefBufferLines is the default number of the console and log lines kept in memory
+	DefBufferLines = 1000
+	// EarlierSize is the maximum size of the earlier output which is loaded from the file at once
+	EarlierSize = 64 * 1024
+)
+
+type EarlierResponse struct {
+	Text   string `json:"text"`
+	Before int64  `json:"before"` // the file offset of the loaded text, 0 - there is no more text
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	// stdoutSpill and logoutSpill are the sizes of the beginning of the .out and .log files
+	// which have been removed from memory
+	stdoutSpill int64
+	logoutSpill int64
+	// logoutPos contains the offsets of logoutBuf lines in the .log file
+	logoutPos []int64
+)
+
+func bufferLines() int {
+	if scriptTask.Header.BufferLines > 0 {
+		return scriptTask.Header.BufferLines
+	}
+	return DefBufferLines
+}
+
+// trimStdout removes the earliest lines from stdoutBuf when it exceeds the limit by half.
+// The lines have already been written to the .out file. mutex must be locked.
+func trimStdout() {
+	limit := bufferLines()
+	// the last line is the current line without the line feed
+	if len(stdoutBuf)-1 <= limit+limit/2 {
+		return
+	}
+	count := len(stdoutBuf) - 1 - limit
+	for _, line := range stdoutBuf[:count] {
+		stdoutSpill += int64(len(outLine(line)) + 2)
+	}
+	stdoutBuf = append(make([]string, 0, limit+1), stdoutBuf[count:]...)
+	iStdout -= count
+	for id, client := range clients {
+		client.StdoutCount -= count
+		if client.StdoutCount < 0 {
+			client.StdoutCount = 0
+		}
+		clients[id] = client
+	}
+}
+
+// trimLogout removes the earliest lines from logoutBuf when it exceeds the limit by half. mutex
+// must be locked.
+func trimLogout() {
+	limit := bufferLines()
+	if len(logoutBuf) <= limit+limit/2 {
+		return
+	}
+	count := len(logoutBuf) - limit
+	logoutSpill = logoutPos[count]
+	logoutBuf = append(make([]string, 0, limit), logoutBuf[count:]...)
+	logoutPos = append(make([]int64, 0, limit), logoutPos[count:]...)
+	iLogout = len(logoutBuf)
+	for id, client := range clients {
+		client.LogoutCount -= count
+		if client.LogoutCount < 0 {
+			client.LogoutCount = 0
+		}
+		clients[id] = client
+	}
+}
+
+// sendEarlier informs the new client that there are earlier lines which are not in memory
+func sendEarlier(client WsClient) error {
+	for _, item := range []struct {
+		Ext   int
+		Spill int64
+	}{
+		{TExtOut, stdoutSpill},
+		{TExtLog, logoutSpill},
+	} {
+		if item.Spill == 0 {
+			continue
+		}
+		if err := client.Conn.WriteJSON(WsCmd{
+			TaskID:  task.ID,
+			Cmd:     WcEarlier,
+			Ref:     TaskExt[item.Ext],
+			Message: strconv.FormatInt(item.Spill, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readEarlier reads the whole lines of the file which are before the offset
+func readEarlier(fname string, before int64) ([]byte, int64, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	finfo, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if before > finfo.Size() {
+		before = finfo.Size()
+	}
+	start := before - EarlierSize
+	if start < 0 {
+		start = 0
+	}
+	data := make([]byte, before-start)
+	if _, err = f.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	if start > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 && i < len(data)-1 {
+			start += int64(i + 1)
+			data = data[i+1:]
+		}
+	}
+	return data, start, nil
+}
+
+func earlierHandle(c echo.Context) error {
+	if err := taskAccess(c); err != nil {
+		return jsonError(c, err)
+	}
+	ext := c.QueryParam(`ext`)
+	if ext != TaskExt[TExtOut] && ext != TaskExt[TExtLog] {
+		return jsonError(c, fmt.Errorf(`invalid file type '%s'`, ext))
+	}
+	before, err := strconv.ParseInt(c.QueryParam(`before`), 10, 64)
+	if err != nil || before < 0 {
+		return jsonError(c, fmt.Errorf(`invalid offset '%s'`, c.QueryParam(`before`)))
+	}
+	data, start, err := readEarlier(filepath.Join(scriptTask.Header.LogDir,
+		fmt.Sprintf(`%08x.%s`, task.ID, ext)), before)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if ext == TaskExt[TExtLog] {
+		text = html.EscapeString(script.FilterLog(text, 0, ``))
+	}
+	return c.JSON(http.StatusOK, &EarlierResponse{Text: text, Before: start})
+}
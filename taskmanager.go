@@ -35,36 +35,55 @@ const ( // TaskStatus
 	TaskTerminated
 	TaskFailed
 	TaskCrashed
+	TaskTimeout // terminated by the watchdog
 
 	TasksPage = 50
 )
 
+// TaskUsage contains the resources used by the task process and its children
+type TaskUsage struct {
+	UserTime   int64 `json:"usertime"`   // user CPU time in milliseconds
+	SystemTime int64 `json:"systime"`    // system CPU time in milliseconds
+	MaxRSS     int64 `json:"maxrss"`     // peak resident set size in bytes
+	ReadBytes  int64 `json:"readbytes"`  // bytes read from the storage
+	WriteBytes int64 `json:"writebytes"` // bytes written to the storage
+}
+
 type Task struct {
-	ID         uint32 `json:"id"`
-	Status     int    `json:"status"`
-	Name       string `json:"name"`
-	IP         string `json:"ip"`
-	StartTime  int64  `json:"start"`
-	FinishTime int64  `json:"finish"`
-	UserID     uint32 `json:"userid"`
-	RoleID     uint32 `json:"roleid"`
-	Port       int    `json:"port"`
-	LocalPort  int    `json:"localport"`
-	Message    string `json:"message,omitempty"`
-	SourceCode string `json:"sourcecode,omitempty"`
-	Locked     bool   `json:"locked"`
+	ID         uint32     `json:"id"`
+	Status     int        `json:"status"`
+	Name       string     `json:"name"`
+	IP         string     `json:"ip"`
+	StartTime  int64      `json:"start"`
+	FinishTime int64      `json:"finish"`
+	UserID     uint32     `json:"userid"`
+	RoleID     uint32     `json:"roleid"`
+	LocalPort  int        `json:"localport"` // deprecated, the local API of tasks uses unix sockets
+	Message    string     `json:"message,omitempty"`
+	SourceCode string     `json:"sourcecode,omitempty"`
+	Locked     bool       `json:"locked"`
+	RetryOf    uint32     `json:"retryof,omitempty"`   // the original task of the retry
+	Attempt    int        `json:"attempt,omitempty"`   // the number of the retry attempt
+	RestartOf  uint32     `json:"restartof,omitempty"` // the crashed task of the restart
+	Agent      string     `json:"agent,omitempty"`     // the agent which runs the task
+	Priority   int        `json:"priority,omitempty"`
+	Depth      int        `json:"depth,omitempty"` // the length of the trigger chain
+	Usage      *TaskUsage `json:"usage,omitempty"`
+
+	timeout   string // the message if the task has been timed out
+	waitStart int64  // the start of waiting for the form
+	waited    int64  // the summary time of waiting for forms
+	claimKey  string // the own key of the tokens of the task running on the agent
 }
 
 var (
-	traceFile      *os.File
 	tasks          map[uint32]*Task
-	ports          [PortsPool]bool
 	prevCheckTasks time.Time
 )
 
 func (task *Task) Head() string {
 	return fmt.Sprintf("%x,%x/%x/%s,%d,%s,%d\r\n", task.ID, task.UserID, task.RoleID, task.IP,
-		task.Port, task.Name, task.StartTime)
+		task.LocalPort, task.Name, task.StartTime)
 }
 
 func taskTrace(unixTime int64, status int, message string) {
@@ -75,34 +94,17 @@ func taskTrace(unixTime int64, status int, message string) {
 	}
 }
 
-func SaveTasks() (err error) {
-	list := ListTasks()
-	var out string
-	for i := len(list) - 1; i >= 0; i-- {
-		out += list[i].String() + "\r\n"
-	}
-	traceFile.Truncate(0)
-	traceFile.Seek(0, 0)
-	_, err = traceFile.Write([]byte(out))
-	return
+func SaveTasks() error {
+	return compactHistory()
 }
 
 func CheckTasks() (err error) {
 	if prevCheckTasks.Add(1 * time.Hour).Before(time.Now()) {
-		list := ListTasks()
-		var count int
-		timeout := time.Now().AddDate(0, 0, -storage.Settings.RemoveAfter)
-		for _, item := range list {
-			if item.Locked {
-				continue
-			}
-			if count > storage.Settings.MaxTasks || time.Unix(item.StartTime, 0).Before(timeout) {
-				RemoveTask(item.ID)
-				continue
-			}
-			count++
+		purge := purgeList()
+		for _, item := range purge {
+			RemoveTask(item.ID)
 		}
-		if len(list) != len(tasks) {
+		if len(purge) > 0 {
 			err = SaveTasks()
 		}
 		prevCheckTasks = time.Now()
@@ -111,19 +113,24 @@ func CheckTasks() (err error) {
 }
 
 func SaveTrace(task *Task) (err error) {
-	if task.Status >= TaskFinished {
-		freePort(task.Port)
-		freePort(task.LocalPort)
-	}
-	_, err = traceFile.Write([]byte(fmt.Sprintf("%s\r\n", task.String())))
-	return
+	return appendHistory(taskEntry{Task: task})
 }
 
+// RemoveTask deletes the task from the history and removes its files
 func RemoveTask(id uint32) {
-	delete(tasks, id)
+	if task, ok := tasks[id]; ok {
+		unindexTask(task)
+		delete(tasks, id)
+		resetHealth(task.Name)
+		if err := appendHistory(taskEntry{Removed: id}); err != nil {
+			golog.Error(err)
+		}
+	}
 	for _, ext := range append(TaskExt, `zip`) {
 		os.Remove(filepath.Join(cfg.Log.Dir, fmt.Sprintf("%08x.%s", id, ext)))
 	}
+	os.Remove(runInputsPath(cfg.Log.Dir, id))
+	removeArtifacts(id)
 }
 
 func GetTaskName(id uint32) (ret string) {
@@ -149,7 +156,7 @@ func ListTasks() []*Task {
 	return ret
 }
 
-func NewTask(header script.Header) (err error) {
+func NewTask(header script.Header, rs *RunScript) (err error) {
 	task := Task{
 		ID:        header.TaskID,
 		Status:    TaskActive,
@@ -158,19 +165,30 @@ func NewTask(header script.Header) (err error) {
 		StartTime: time.Now().Unix(),
 		UserID:    header.User.ID,
 		RoleID:    header.User.RoleID,
-		Port:      header.HTTP.Port,
-		LocalPort: header.HTTP.LocalPort,
+		RetryOf:   rs.RetryOf,
+		Attempt:   rs.Attempt,
+		RestartOf: rs.RestartOf,
+		Agent:     rs.agent,
+		Priority:  rs.Priority,
+		Depth:     rs.Depth,
 	}
 	if header.Role.ID >= users.ResRoleID {
 		task.RoleID = header.Role.ID
 	}
-	if err = SaveTrace(&task); err != nil {
-		return
+	if len(task.Agent) > 0 {
+		task.claimKey = header.ClaimKey
 	}
 	if _, ok := tasks[task.ID]; ok {
 		return fmt.Errorf(`task %x exists`, task.ID)
 	}
-	tasks[task.ID] = &task
+	if err = saveRunInputs(task.ID, rs); err != nil {
+		return
+	}
+	if err = SaveTrace(&task); err != nil {
+		return
+	}
+	storeTask(&task)
+	SendWebhooks(&task, HookStart)
 	return CheckTasks()
 }
 
@@ -180,10 +198,11 @@ func (task *Task) String() string {
 		locked = `*`
 	}
 	return fmt.Sprintf("%x,%x/%x/%s,%d,%s,%d,%d,%d%s,%s", task.ID, task.UserID, task.RoleID, task.IP,
-		task.Port, task.Name,
+		task.LocalPort, task.Name,
 		task.StartTime, task.FinishTime, task.Status, locked, task.Message)
 }
 
+// LogToTask parses the line of the deprecated tasks.trace file
 func LogToTask(input string) (task Task, err error) {
 	var (
 		uival uint64
@@ -211,10 +230,9 @@ func LogToTask(input string) (task Task, err error) {
 		} else {
 			task.RoleID = users.XAdminID
 		}
-		if uival, err = strconv.ParseUint(vals[2], 10, 32); err != nil {
+		if _, err = strconv.ParseUint(vals[2], 10, 32); err != nil {
 			return
 		}
-		task.Port = int(uival)
 		task.Name = vals[3]
 		if ival, err = strconv.ParseInt(vals[4], 10, 64); err != nil {
 			return
@@ -241,25 +259,13 @@ func LogToTask(input string) (task Task, err error) {
 }
 
 func InitTaskManager() (err error) {
-	filename := filepath.Join(cfg.Log.Dir, `tasks.trace`)
-	traceFile, err = os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
-	if err != nil {
+	if err = OpenHistory(); err != nil {
 		return
 	}
-	tasks = make(map[uint32]*Task)
-	input, err := os.ReadFile(filename)
-	if err != nil {
-		return
-	}
-	for _, item := range strings.Split(string(input), "\n") {
-		if task, err := LogToTask(strings.TrimSpace(item)); err == nil {
-			tasks[task.ID] = &task
-		}
-	}
 	for key, item := range tasks {
 		if item.Status < TaskFinished {
 			active := false
-			body, err := lib.LocalGet(item.LocalPort, `info`)
+			body, err := taskGet(item, `info`)
 			if err == nil {
 				var task Task
 				if err = json.Unmarshal(body, &task); err == nil && task.ID == item.ID {
@@ -270,43 +276,41 @@ func InitTaskManager() (err error) {
 			if !active {
 				tasks[key].Status = TaskCrashed
 				tasks[key].FinishTime = time.Now().Unix()
+				if err = SaveTrace(tasks[key]); err != nil {
+					return err
+				}
+				crashedTasks = append(crashedTasks, tasks[key])
 			}
 		}
 	}
-	err = CheckTasks()
+	if err = CheckTasks(); err != nil {
+		return
+	}
+	if err = LoadRunQueue(); err != nil {
+		return
+	}
+	if err = LoadDeliveries(); err != nil {
+		return
+	}
+	go taskWatchdog()
 	return
 }
 
 func CloseTaskManager() {
-	traceFile.Close()
-}
-
-func freePort(port int) {
-	i := port - cfg.HTTP.Port - 1
-	if i < PortsPool {
-		ports[i] = false
-	}
+	CloseHistory()
 }
 
+// getPort returns the first free port after the port of the web-server. It is used for
+// the local web-server if the port has not been specified.
 func getPort() (int, error) {
-	var (
-		i    int
-		port int
-	)
-	for ; i < PortsPool; i++ {
-		if !ports[i] {
-			port = cfg.HTTP.Port + 1 + i
-			if ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port)); err == nil {
-				_ = ln.Close()
-				ports[i] = true
-				break
-			}
+	for i := 1; i <= PortsPool; i++ {
+		port := cfg.HTTP.Port + i
+		if ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port)); err == nil {
+			_ = ln.Close()
+			return port, nil
 		}
 	}
-	if i == PortsPool {
-		return i, fmt.Errorf(`There is not available port in the pool`)
-	}
-	return port, nil
+	return 0, fmt.Errorf(`There is not available port in the pool`)
 }
 
 func wsMainHandle(c echo.Context) error {
@@ -349,7 +353,7 @@ func GetTaskFiles(id uint32, render bool) (ret []string, replist []script.Report
 	}
 
 	for i, ext := range TaskExt {
-		if i == TExtTrace {
+		if i == TExtTrace || i == TExtTimeline {
 			continue
 		}
 		if out, err = os.ReadFile(filepath.Join(cfg.Log.Dir, fname+ext)); err == nil {
@@ -357,7 +361,7 @@ func GetTaskFiles(id uint32, render bool) (ret []string, replist []script.Report
 		}
 	}
 	if len(ret[TExtLog]) > 0 || len(ret[TExtOut]) > 0 || len(ret[TExtSrc]) > 0 ||
-		len(ret[TExtReport]) > 0 {
+		len(ret[TExtReport]) > 0 || len(ret[TExtArtifact]) > 0 || len(ret[TExtInputs]) > 0 {
 		return
 	}
 	r, err := zip.OpenReader(filepath.Join(cfg.Log.Dir, fname+`zip`))
@@ -369,7 +373,7 @@ func GetTaskFiles(id uint32, render bool) (ret []string, replist []script.Report
 	}()
 	for _, f := range r.File {
 		for i, ext := range TaskExt {
-			if i == TExtTrace {
+			if i == TExtTrace || i == TExtTimeline {
 				continue
 			}
 			if len(ret[i]) == 0 && f.Name == fname+ext {
@@ -0,0 +1,129 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// taskTransport connects to the web-servers of tasks
+	taskTransport = socketTransport(taskSocket)
+	// localTransport connects to the local web-servers of tasks
+	localTransport = socketTransport(localSocket)
+)
+
+// socketTransport connects to the unix sockets of tasks. The host of the request URL is
+// the hexadecimal task ID.
+func socketTransport(socket func(uint32) string) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			id, err := strconv.ParseUint(host, 16, 32)
+			if err != nil {
+				return nil, err
+			}
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, `unix`, socket(uint32(id)))
+		},
+	}
+}
+
+// taskSocket returns the unix socket of the task web-server
+func taskSocket(taskID uint32) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf(`eonza-%08x.sock`, taskID))
+}
+
+// localSocket returns the unix socket of the local web-server of the task
+func localSocket(taskID uint32) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf(`eonza-%08x.local.sock`, taskID))
+}
+
+// isSocketRequest returns true if the request has been received through the unix socket
+func isSocketRequest(c echo.Context) bool {
+	addr, ok := c.Request().Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == `unix`
+}
+
+// localGet sends the request to the local web-server of the task process on this computer
+func localGet(taskID uint32, url string) ([]byte, error) {
+	client := &http.Client{Transport: localTransport}
+	res, err := client.Get(fmt.Sprintf(`http://%08x/%s`, taskID, url))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+// taskURL returns the URL of the task page on the main web-server
+func taskURL(taskID uint32) string {
+	if taskID == 0 {
+		return ``
+	}
+	return fmt.Sprintf(`/task/%d/`, taskID)
+}
+
+// listenTask creates the listener of the task web-server. It is accessible only through
+// the main web-server.
+func listenTask(taskID uint32) (net.Listener, error) {
+	return listenSocket(taskSocket(taskID))
+}
+
+func listenSocket(socket string) (net.Listener, error) {
+	os.Remove(socket)
+	return net.Listen(`unix`, socket)
+}
+
+func proxyTaskHandle(c echo.Context) error {
+	ptask, _, err := showTaskAccess(c, c.Param(`id`))
+	if err != nil {
+		return err
+	}
+	path := c.Param(`*`)
+	if ptask.Status >= TaskFinished && len(path) == 0 {
+		return c.Redirect(http.StatusFound, fmt.Sprintf(`/task/%d`, ptask.ID))
+	}
+	return proxyTask(c, ptask, path)
+}
+
+// proxyTask passes the request to the path of the task web-server
+func proxyTask(c echo.Context, ptask *Task, path string) error {
+	ip := c.RealIP()
+	// AuthHandle locks the mutex for the request but the proxied websocket connection lasts
+	// until the task page has been closed
+	mutex.Unlock()
+	defer mutex.Lock()
+	if len(ptask.Agent) > 0 {
+		return proxyAgentTask(c, ptask, path, ip)
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = `http`
+			req.URL.Host = fmt.Sprintf(`%08x`, ptask.ID)
+			req.URL.Path = `/` + path
+			req.URL.RawPath = ``
+			// the task web-server checks the IP address of the user
+			req.Header.Set(XForwardedFor, ip)
+			req.Header.Del(XRealIP)
+		},
+		Transport: taskTransport,
+	}
+	proxy.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"eonza/users"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// SearchLimit is the maximum number of found tasks
+	SearchLimit = 100
+	// SearchExcerpts is the maximum number of excerpts for each found task
+	SearchExcerpts = 10
+	// ExcerptLen is the maximum length of the excerpt
+	ExcerptLen = 200
+)
+
+// SearchMatch is the line containing the search string
+type SearchMatch struct {
+	Source string `json:"source"` // out, log or the title of the report
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+}
+
+type SearchTask struct {
+	ID         uint32        `json:"id"`
+	Status     int           `json:"status"`
+	Name       string        `json:"name"`
+	StartTime  string        `json:"start"`
+	FinishTime string        `json:"finish"`
+	Matches    []SearchMatch `json:"matches"`
+}
+
+type SearchResponse struct {
+	List  []SearchTask `json:"list"`
+	More  bool         `json:"more,omitempty"` // true if SearchLimit has been reached
+	Error string       `json:"error,omitempty"`
+}
+
+// taskVisible returns true if the user can view the task
+func taskVisible(user *users.User, taskFlag int, item *Task) bool {
+	return user.RoleID == users.XAdminID || (taskFlag&4 == 4) ||
+		(taskFlag&1 == 1 && user.ID == item.UserID) ||
+		(taskFlag&2 == 2 && user.RoleID == item.RoleID)
+}
+
+// excerpt cuts the line around the match
+func excerpt(line string, loc []int) string {
+	if len(line) <= ExcerptLen {
+		return line
+	}
+	start := loc[0] - (ExcerptLen-(loc[1]-loc[0]))/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + ExcerptLen
+	if end > len(line) {
+		end = len(line)
+		start = end - ExcerptLen
+	}
+	ret := strings.ToValidUTF8(line[start:end], ``)
+	if start > 0 {
+		ret = `…` + ret
+	}
+	if end < len(line) {
+		ret += `…`
+	}
+	return ret
+}
+
+func searchText(re *regexp.Regexp, source, text string, matches []SearchMatch) []SearchMatch {
+	for i, line := range strings.Split(text, "\n") {
+		if len(matches) >= SearchExcerpts {
+			break
+		}
+		line = strings.TrimRight(line, "\r")
+		if loc := re.FindStringIndex(line); loc != nil {
+			matches = append(matches, SearchMatch{
+				Source: source,
+				Line:   i + 1,
+				Text:   excerpt(line, loc),
+			})
+		}
+	}
+	return matches
+}
+
+// SearchTaskFiles returns the lines of the task output, log and reports matching the expression
+func SearchTaskFiles(re *regexp.Regexp, id uint32) []SearchMatch {
+	matches := make([]SearchMatch, 0)
+	files, replist := GetTaskFiles(id, false)
+	matches = searchText(re, TaskExt[TExtOut], files[TExtOut], matches)
+	matches = searchText(re, TaskExt[TExtLog], files[TExtLog], matches)
+	for _, rep := range replist {
+		matches = searchText(re, rep.Title, rep.Body, matches)
+	}
+	return matches
+}
+
+// searchTasksHandle finds tasks which stdout, log or reports contain the string or match
+// the regular expression. It takes the same filter parameters as /api/tasks.
+func searchTasksHandle(c echo.Context) error {
+	var (
+		re       *regexp.Regexp
+		err      error
+		taskFlag int
+	)
+	query := c.QueryParam(`q`)
+	if len(query) == 0 {
+		return jsonError(c, Lang(DefLang, `errreq`, `q`))
+	}
+	if c.QueryParam(`regex`) == `true` {
+		re, err = regexp.Compile(query)
+	} else {
+		re, err = regexp.Compile(`(?i)` + regexp.QuoteMeta(query))
+	}
+	if err != nil {
+		return jsonError(c, fmt.Errorf(`invalid regular expression: %s`, err.Error()))
+	}
+	filter, err := taskFilter(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	user := c.(*Auth).User
+	if user.RoleID != users.XAdminID {
+		if role, ok := GetRole(user.RoleID); ok {
+			taskFlag = role.Tasks
+		}
+	}
+	list := make([]SearchTask, 0)
+	for _, item := range QueryTasks(filter) {
+		if !taskVisible(user, taskFlag, item) || (item.FinishTime == 0 &&
+			user.RoleID != users.XAdminID && user.ID != item.UserID) {
+			continue
+		}
+		var finish string
+		if item.FinishTime > 0 {
+			finish = time.Unix(item.FinishTime, 0).Format(TimeFormat)
+		}
+		list = append(list, SearchTask{
+			ID:         item.ID,
+			Status:     item.Status,
+			Name:       item.Name,
+			StartTime:  time.Unix(item.StartTime, 0).Format(TimeFormat),
+			FinishTime: finish,
+		})
+	}
+	// reading of archives can take a long time so the search does not lock other requests
+	mutex.Unlock()
+	defer mutex.Lock()
+	var response SearchResponse
+	response.List = make([]SearchTask, 0)
+	for _, item := range list {
+		if item.Matches = SearchTaskFiles(re, item.ID); len(item.Matches) == 0 {
+			continue
+		}
+		if len(response.List) == SearchLimit {
+			response.More = true
+			break
+		}
+		response.List = append(response.List, item)
+	}
+	return c.JSON(http.StatusOK, &response)
+}
@@ -0,0 +1,365 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"eonza/lib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+const (
+	// TaskHistory is the file name of the task history journal
+	TaskHistory = `tasks.history`
+	// TaskTraceFile is the file name of the deprecated CSV task history
+	TaskTraceFile = `tasks.trace`
+)
+
+// taskEntry is a line of the task history journal. The last entry with the same ID wins.
+type taskEntry struct {
+	Task    *Task  `json:"task,omitempty"`
+	Removed uint32 `json:"removed,omitempty"`
+}
+
+// TaskFilter contains the conditions of the task history query
+type TaskFilter struct {
+	Name       string
+	User       string
+	Role       string
+	Status     []int
+	StartFrom  int64
+	StartTo    int64
+	FinishFrom int64
+	FinishTo   int64
+	RetryOf    uint32 // the original task and all its retries
+	Sort       string
+	Asc        bool
+}
+
+var (
+	historyFile  *os.File
+	historyMutex = &sync.Mutex{}
+	tasksByName  map[string]map[uint32]*Task
+
+	taskStatusNames = []string{`start`, `active`, `waiting`, `suspended`, `finished`,
+		`terminated`, `failed`, `crashed`, `timeout`}
+)
+
+func historyPath() string {
+	return filepath.Join(cfg.Log.Dir, TaskHistory)
+}
+
+func indexTask(task *Task) {
+	name := lib.IdName(task.Name)
+	if tasksByName[name] == nil {
+		tasksByName[name] = make(map[uint32]*Task)
+	}
+	tasksByName[name][task.ID] = task
+}
+
+func unindexTask(task *Task) {
+	name := lib.IdName(task.Name)
+	if list := tasksByName[name]; list != nil {
+		delete(list, task.ID)
+		if len(list) == 0 {
+			delete(tasksByName, name)
+		}
+	}
+}
+
+// storeTask puts the task into the memory and indexes it
+func storeTask(task *Task) {
+	if cur, ok := tasks[task.ID]; ok {
+		unindexTask(cur)
+	}
+	tasks[task.ID] = task
+	indexTask(task)
+}
+
+// OpenHistory loads the task history. It imports the deprecated tasks.trace file if the
+// journal does not exist.
+func OpenHistory() (err error) {
+	tasks = make(map[uint32]*Task)
+	tasksByName = make(map[string]map[uint32]*Task)
+	filename := historyPath()
+	if _, err = os.Stat(filename); os.IsNotExist(err) {
+		err = importTrace()
+	} else if err == nil {
+		err = loadHistory(filename)
+	}
+	if err != nil {
+		return
+	}
+	return compactHistory()
+}
+
+func loadHistory(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry taskEntry
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if err = json.Unmarshal(line, &entry); err != nil {
+			// the last line can be broken if the application has crashed while writing
+			golog.Warn(`task history: `, err)
+			continue
+		}
+		if entry.Removed != 0 {
+			if cur, ok := tasks[entry.Removed]; ok {
+				unindexTask(cur)
+				delete(tasks, entry.Removed)
+			}
+		} else if entry.Task != nil {
+			storeTask(entry.Task)
+		}
+	}
+	return scanner.Err()
+}
+
+func importTrace() error {
+	filename := filepath.Join(cfg.Log.Dir, TaskTraceFile)
+	input, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, item := range strings.Split(string(input), "\n") {
+		if task, err := LogToTask(strings.TrimSpace(item)); err == nil {
+			itask := task
+			storeTask(&itask)
+		}
+	}
+	if err = compactHistory(); err != nil {
+		return err
+	}
+	return os.Rename(filename, filename+`.bak`)
+}
+
+// appendHistory writes the entry to the end of the journal
+func appendHistory(entry taskEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if _, err = historyFile.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return historyFile.Sync()
+}
+
+// compactHistory rewrites the journal with the actual tasks. The new file replaces the old one
+// only after it has been written completely.
+func compactHistory() (err error) {
+	var f *os.File
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	filename := historyPath()
+	tmpname := filename + `.tmp`
+	if f, err = os.OpenFile(tmpname, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666); err != nil {
+		return
+	}
+	list := ListTasks()
+	writer := bufio.NewWriter(f)
+	for i := len(list) - 1; i >= 0 && err == nil; i-- {
+		var data []byte
+		if data, err = json.Marshal(taskEntry{Task: list[i]}); err == nil {
+			_, err = writer.Write(append(data, '\n'))
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmpname)
+		return
+	}
+	if historyFile != nil {
+		historyFile.Close()
+		historyFile = nil
+	}
+	if err = os.Rename(tmpname, filename); err != nil {
+		return
+	}
+	historyFile, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	return
+}
+
+func CloseHistory() {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if historyFile != nil {
+		historyFile.Close()
+		historyFile = nil
+	}
+}
+
+// ParseTaskStatus converts the name or the number of the status
+func ParseTaskStatus(value string) (int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for i, name := range taskStatusNames {
+		if name == value {
+			return i, nil
+		}
+	}
+	status, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || status < 0 || int(status) >= len(taskStatusNames) {
+		return 0, fmt.Errorf(`invalid task status '%s'`, value)
+	}
+	return int(status), nil
+}
+
+// ParseFilterTime converts the date or the unix time. If end is true then the date without
+// time means the end of that day.
+func ParseFilterTime(value string, end bool) (int64, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unix, nil
+	}
+	for _, layout := range []string{TimeFormat, `2006-01-02 15:04:05`, `2006-01-02T15:04`,
+		`2006-01-02 15:04`} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	for _, layout := range []string{`2006/01/02`, `2006-01-02`} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			if end {
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf(`invalid time '%s'`, value)
+}
+
+func (filter *TaskFilter) match(task *Task) bool {
+	if filter.RetryOf != 0 && task.ID != filter.RetryOf && task.RetryOf != filter.RetryOf {
+		return false
+	}
+	if len(filter.Status) > 0 {
+		var ok bool
+		for _, status := range filter.Status {
+			if task.Status == status {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if (filter.StartFrom > 0 && task.StartTime < filter.StartFrom) ||
+		(filter.StartTo > 0 && task.StartTime > filter.StartTo) {
+		return false
+	}
+	if (filter.FinishFrom > 0 || filter.FinishTo > 0) && task.FinishTime == 0 {
+		return false
+	}
+	if (filter.FinishFrom > 0 && task.FinishTime < filter.FinishFrom) ||
+		(filter.FinishTo > 0 && task.FinishTime > filter.FinishTo) {
+		return false
+	}
+	if len(filter.User) > 0 || len(filter.Role) > 0 {
+		userName, roleName := GetUserRole(task.UserID, task.RoleID)
+		if (len(filter.User) > 0 && !strings.EqualFold(filter.User, userName)) ||
+			(len(filter.Role) > 0 && !strings.EqualFold(filter.Role, roleName)) {
+			return false
+		}
+	}
+	return true
+}
+
+func taskDuration(task *Task) int64 {
+	if task.FinishTime == 0 {
+		return time.Now().Unix() - task.StartTime
+	}
+	return task.FinishTime - task.StartTime
+}
+
+// QueryTasks returns the sorted list of tasks matching the filter
+func QueryTasks(filter TaskFilter) []*Task {
+	var source map[uint32]*Task
+
+	if len(filter.Name) > 0 {
+		source = tasksByName[lib.IdName(filter.Name)]
+	} else {
+		source = tasks
+	}
+	ret := make([]*Task, 0, len(source))
+	for _, task := range source {
+		if filter.match(task) {
+			ret = append(ret, task)
+		}
+	}
+	less := func(i, j int) bool {
+		if ret[i].StartTime == ret[j].StartTime {
+			return ret[i].FinishTime < ret[j].FinishTime
+		}
+		return ret[i].StartTime < ret[j].StartTime
+	}
+	switch filter.Sort {
+	case `finish`:
+		less = func(i, j int) bool {
+			if ret[i].FinishTime == ret[j].FinishTime {
+				return ret[i].StartTime < ret[j].StartTime
+			}
+			return ret[i].FinishTime < ret[j].FinishTime
+		}
+	case `name`:
+		less = func(i, j int) bool {
+			if ret[i].Name == ret[j].Name {
+				return ret[i].StartTime < ret[j].StartTime
+			}
+			return ret[i].Name < ret[j].Name
+		}
+	case `status`:
+		less = func(i, j int) bool {
+			if ret[i].Status == ret[j].Status {
+				return ret[i].StartTime < ret[j].StartTime
+			}
+			return ret[i].Status < ret[j].Status
+		}
+	case `duration`:
+		less = func(i, j int) bool {
+			return taskDuration(ret[i]) < taskDuration(ret[j])
+		}
+	}
+	if filter.Asc {
+		sort.SliceStable(ret, less)
+	} else {
+		sort.SliceStable(ret, func(i, j int) bool { return less(j, i) })
+	}
+	return ret
+}
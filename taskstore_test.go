@@ -0,0 +1,113 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// setTestTasks replaces the task history with the list of tasks
+func setTestTasks(list ...*Task) {
+	tasks = make(map[uint32]*Task)
+	tasksByName = make(map[string]map[uint32]*Task)
+	for _, item := range list {
+		storeTask(item)
+	}
+}
+
+func TestParseTaskStatus(t *testing.T) {
+	for _, test := range []struct {
+		value  string
+		status int
+		err    bool
+	}{
+		{`start`, TaskStart, false},
+		{`Finished`, TaskFinished, false},
+		{` timeout `, TaskTimeout, false},
+		{`6`, TaskFailed, false},
+		{`0`, TaskStart, false},
+		{`9`, 0, true},
+		{`-1`, 0, true},
+		{`done`, 0, true},
+		{``, 0, true},
+	} {
+		status, err := ParseTaskStatus(test.value)
+		if (err != nil) != test.err {
+			t.Errorf(`%q: error %v`, test.value, err)
+		} else if status != test.status {
+			t.Errorf(`%q: %d != %d`, test.value, status, test.status)
+		}
+	}
+}
+
+func TestParseFilterTime(t *testing.T) {
+	day := time.Date(2021, 3, 15, 0, 0, 0, 0, time.Local).Unix()
+	for _, test := range []struct {
+		value string
+		end   bool
+		want  int64
+		err   bool
+	}{
+		{``, false, 0, false},
+		{`1615766400`, false, 1615766400, false},
+		{`2021/03/15`, false, day, false},
+		{`2021-03-15`, false, day, false},
+		{`2021-03-15`, true, day + 24*3600 - 1, false},
+		{`2021/03/15 10:20:30`, true, day + 10*3600 + 20*60 + 30, false},
+		{`2021-03-15 10:20:30`, false, day + 10*3600 + 20*60 + 30, false},
+		{`2021-03-15T10:20`, false, day + 10*3600 + 20*60, false},
+		{`2021-03-15 10:20`, true, day + 10*3600 + 20*60, false},
+		{`15.03.2021`, false, 0, true},
+		{`yesterday`, false, 0, true},
+	} {
+		got, err := ParseFilterTime(test.value, test.end)
+		if (err != nil) != test.err {
+			t.Errorf(`%q: error %v`, test.value, err)
+		} else if got != test.want {
+			t.Errorf(`%q: %d != %d`, test.value, got, test.want)
+		}
+	}
+}
+
+func TestQueryTasks(t *testing.T) {
+	setTestTasks(
+		&Task{ID: 1, Name: `backup`, Status: TaskFinished, StartTime: 100, FinishTime: 160},
+		&Task{ID: 2, Name: `backup`, Status: TaskFailed, StartTime: 200, FinishTime: 210},
+		&Task{ID: 3, Name: `report-day`, Status: TaskActive, StartTime: 300},
+		&Task{ID: 4, Name: `report.day`, Status: TaskFinished, StartTime: 400, FinishTime: 500},
+		&Task{ID: 5, Name: `backup`, Status: TaskFinished, StartTime: 500, FinishTime: 505,
+			RetryOf: 2},
+	)
+	for _, test := range []struct {
+		filter TaskFilter
+		want   string
+	}{
+		{TaskFilter{}, `[5 4 3 2 1]`},
+		{TaskFilter{Asc: true}, `[1 2 3 4 5]`},
+		{TaskFilter{Name: `backup`}, `[5 2 1]`},
+		{TaskFilter{Name: `report_day`, Asc: true}, `[3 4]`},
+		{TaskFilter{Name: `unknown`}, `[]`},
+		{TaskFilter{Status: []int{TaskFinished}}, `[5 4 1]`},
+		{TaskFilter{Status: []int{TaskFailed, TaskActive}}, `[3 2]`},
+		{TaskFilter{StartFrom: 200, StartTo: 400}, `[4 3 2]`},
+		{TaskFilter{FinishFrom: 200}, `[5 4 2]`},
+		{TaskFilter{FinishTo: 200}, `[1]`},
+		{TaskFilter{RetryOf: 2}, `[5 2]`},
+		{TaskFilter{Sort: `finish`, Asc: true, Status: []int{TaskFinished}}, `[1 4 5]`},
+		{TaskFilter{Sort: `name`, Asc: true}, `[1 2 5 3 4]`},
+		{TaskFilter{Sort: `status`}, `[2 5 4 1 3]`},
+		{TaskFilter{Sort: `duration`, Status: []int{TaskFinished, TaskFailed}}, `[4 1 2 5]`},
+	} {
+		ids := make([]uint32, 0)
+		for _, item := range QueryTasks(test.filter) {
+			ids = append(ids, item.ID)
+		}
+		if got := fmt.Sprint(ids); got != test.want {
+			t.Errorf(`%+v: %s != %s`, test.filter, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"eonza/script"
+	"eonza/users"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kataras/golog"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// StreamPing is the interval of keep-alive comments of the event stream in seconds
+	StreamPing = 30
+	// StreamWait is the time of waiting for the final status of the task in seconds
+	StreamWait = 5
+)
+
+const ( // The events of the task stream
+	StreamStdout   = `stdout`
+	StreamLog      = `log`
+	StreamProgress = `progress`
+	StreamForm     = `form`
+	StreamStatus   = `status`
+)
+
+type StreamStatusData struct {
+	Status     int    `json:"status"`
+	StatusName string `json:"statusname"`
+	Message    string `json:"message,omitempty"`
+	Finish     string `json:"finish,omitempty"`
+}
+
+// taskStream writes the task output as server-sent events or as plain text
+type taskStream struct {
+	resp *echo.Response
+	text bool
+}
+
+func (stream *taskStream) event(event, data string) {
+	if stream.text {
+		switch event {
+		case StreamStdout, StreamLog:
+			fmt.Fprintln(stream.resp, data)
+		default:
+			return
+		}
+	} else {
+		fmt.Fprintf(stream.resp, "event: %s\n", event)
+		for _, line := range strings.Split(data, "\n") {
+			fmt.Fprintf(stream.resp, "data: %s\n", line)
+		}
+		fmt.Fprint(stream.resp, "\n")
+	}
+	stream.resp.Flush()
+}
+
+func (stream *taskStream) lines(event, data string) {
+	if len(data) == 0 {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(data, "\r\n"), "\n") {
+		stream.event(event, strings.TrimRight(line, "\r"))
+	}
+}
+
+func (stream *taskStream) status(status int, message, finish string) {
+	if stream.text {
+		line := `# ` + taskStatusNames[status]
+		if len(message) > 0 {
+			line += `: ` + message
+		}
+		fmt.Fprintln(stream.resp, line)
+		stream.resp.Flush()
+		return
+	}
+	data, _ := json.Marshal(StreamStatusData{
+		Status:     status,
+		StatusName: taskStatusNames[status],
+		Message:    message,
+		Finish:     finish,
+	})
+	stream.event(StreamStatus, string(data))
+}
+
+func (stream *taskStream) ping() {
+	if !stream.text {
+		fmt.Fprint(stream.resp, ": ping\n\n")
+		stream.resp.Flush()
+	}
+}
+
+// dialTask connects to the websocket of the task web-server directly or through the agent
+func dialTask(ctx context.Context, ptask *Task) (*websocket.Conn, error) {
+	taskID, agentName := ptask.ID, ptask.Agent
+	token, err := taskToken(ptask, &Claims{RoleID: users.XAdminID})
+	if err != nil {
+		return nil, err
+	}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 5 * time.Second,
+	}
+	header := http.Header{}
+	header.Set(XForwardedFor, `127.0.0.1`)
+	header.Set(`Cookie`, `jwt=`+token)
+	wsURL := fmt.Sprintf(`ws://%s/ws`, cfg.HTTP.Host)
+	if len(agentName) > 0 {
+		agent, err := getAgent(agentName)
+		if err != nil {
+			return nil, err
+		}
+		wsURL = fmt.Sprintf(`ws%s/agent/task/%d/ws`, strings.TrimPrefix(agent.URL, `http`), taskID)
+		// the task web-server checks the host of the main web-server
+		header.Set(`Host`, cfg.HTTP.Host)
+		header.Set(echo.HeaderAuthorization, `Bearer `+cfg.Agent.Token)
+	} else {
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, `unix`, taskSocket(taskID))
+		}
+	}
+	ws, _, err := dialer.DialContext(ctx, wsURL, header)
+	return ws, err
+}
+
+// agentEarlier requests the earlier output of the task from its web-server through the agent
+func agentEarlier(ptask *Task, ext string, before int64) (string, int64, error) {
+	agent, err := getAgent(ptask.Agent)
+	if err != nil {
+		return ``, 0, err
+	}
+	token, err := taskToken(ptask, &Claims{RoleID: users.XAdminID})
+	if err != nil {
+		return ``, 0, err
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(`%s/agent/task/%d/earlier?ext=%s&before=%d`,
+		agent.URL, ptask.ID, ext, before), nil)
+	if err != nil {
+		return ``, 0, err
+	}
+	// the task web-server checks the host of the main web-server
+	req.Host = cfg.HTTP.Host
+	req.Header.Set(XForwardedFor, `127.0.0.1`)
+	req.Header.Set(echo.HeaderAuthorization, `Bearer `+cfg.Agent.Token)
+	req.Header.Set(`Cookie`, `jwt=`+token)
+	resp, err := agentClient.Do(req)
+	if err != nil {
+		return ``, 0, err
+	}
+	defer resp.Body.Close()
+	var earlier EarlierResponse
+	if err = json.NewDecoder(resp.Body).Decode(&earlier); err != nil {
+		return ``, 0, err
+	}
+	if len(earlier.Error) > 0 {
+		return ``, 0, errors.New(earlier.Error)
+	}
+	if ext == TaskExt[TExtLog] {
+		earlier.Text = html.UnescapeString(earlier.Text)
+	}
+	return earlier.Text, earlier.Before, nil
+}
+
+// taskEarlier returns the whole lines of the output file of the task which are before the offset
+// and the offset of these lines
+func taskEarlier(ptask *Task, ext string, before int64) (string, int64, error) {
+	if len(ptask.Agent) > 0 {
+		return agentEarlier(ptask, ext, before)
+	}
+	data, start, err := readEarlier(filepath.Join(cfg.Log.Dir, fmt.Sprintf(`%08x.%s`, ptask.ID,
+		ext)), before)
+	if err != nil {
+		return ``, 0, err
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if ext == TaskExt[TExtLog] {
+		text = script.FilterLog(text, 0, ``)
+	}
+	return text, start, nil
+}
+
+// earlier sends the lines which have been removed from the memory of the task before the live
+// output. They are read from the end of the spilled part to its beginning.
+func (stream *taskStream) earlier(ptask *Task, ext string, before int64) error {
+	var event string
+	switch ext {
+	case TaskExt[TExtOut]:
+		event = StreamStdout
+	case TaskExt[TExtLog]:
+		event = StreamLog
+	default:
+		return nil
+	}
+	chunks := make([]string, 0)
+	for before > 0 {
+		text, start, err := taskEarlier(ptask, ext, before)
+		if err != nil {
+			return err
+		}
+		if start >= before {
+			break
+		}
+		chunks = append(chunks, text)
+		before = start
+	}
+	for i := len(chunks) - 1; i >= 0; i-- {
+		stream.lines(event, chunks[i])
+	}
+	return nil
+}
+
+// live streams the output of the running task. It returns true if the final status has been sent.
+func (stream *taskStream) live(ctx context.Context, ws *websocket.Conn, ptask *Task) bool {
+	var stdbuf string
+
+	chCmd := make(chan WsCmd)
+	go func() {
+		defer close(chCmd)
+		for {
+			var cmd WsCmd
+			if err := ws.ReadJSON(&cmd); err != nil {
+				return
+			}
+			select {
+			case chCmd <- cmd:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(StreamPing * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			stream.ping()
+		case cmd, ok := <-chCmd:
+			if !ok {
+				return false
+			}
+			switch cmd.Cmd {
+			case WcEarlier:
+				before, _ := strconv.ParseInt(cmd.Message, 10, 64)
+				if err := stream.earlier(ptask, cmd.Ref, before); err != nil {
+					golog.Error(err)
+				}
+			case WcStdout:
+				stdbuf = ``
+				stream.lines(StreamStdout, cmd.Message)
+			case WcStdbuf:
+				stdbuf = cmd.Message
+			case WcLogout:
+				stream.lines(StreamLog, html.UnescapeString(cmd.Message))
+			case WcProgress:
+				stream.event(StreamProgress, cmd.Message)
+			case WcForm:
+				stream.event(StreamForm, cmd.Message)
+			case WcStatus:
+				if cmd.Status >= TaskFinished && len(stdbuf) > 0 {
+					stream.lines(StreamStdout, stdbuf)
+				}
+				stream.status(cmd.Status, cmd.Message, cmd.Time)
+				if cmd.Status >= TaskFinished {
+					return true
+				}
+			}
+		}
+	}
+}
+
+// streamTaskHandle sends the output of the task as server-sent events or as plain text if
+// format=text. The output is replayed from the start.
+func streamTaskHandle(c echo.Context) error {
+	ptask, _, err := showTaskAccess(c, c.Param(`id`))
+	if err != nil || ptask == nil {
+		return err
+	}
+	id := ptask.ID
+	// the fields of the task which are used by dialTask are not changed while it is running
+	target := ptask
+	running := ptask.Status < TaskFinished
+	stream := &taskStream{
+		resp: c.Response(),
+		text: c.QueryParam(`format`) == `text`,
+	}
+	// the stream lasts until the task has been finished so it must not lock other requests
+	mutex.Unlock()
+	defer mutex.Lock()
+
+	header := stream.resp.Header()
+	if stream.text {
+		header.Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	} else {
+		header.Set(echo.HeaderContentType, `text/event-stream`)
+		header.Set(`Cache-Control`, `no-cache`)
+		header.Set(`X-Accel-Buffering`, `no`)
+	}
+	stream.resp.WriteHeader(http.StatusOK)
+	stream.resp.Flush()
+
+	ctx := c.Request().Context()
+	var replayed bool
+	if running {
+		var (
+			ws  *websocket.Conn
+			err error
+		)
+		// the web-server of the just started task can be not ready yet
+		for i := 0; i < StreamWait*10; i++ {
+			if ws, err = dialTask(ctx, target); err == nil || ctx.Err() != nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err == nil {
+			replayed = true
+			final := stream.live(ctx, ws, target)
+			ws.Close()
+			if final || ctx.Err() != nil {
+				return nil
+			}
+		}
+	}
+	// the task has been finished or its web-server is not available
+	var status int
+	var message, finish string
+	for i := 0; i < StreamWait*10; i++ {
+		mutex.Lock()
+		if ptask = tasks[id]; ptask != nil {
+			status = ptask.Status
+			message = ptask.Message
+			if ptask.FinishTime > 0 {
+				finish = time.Unix(ptask.FinishTime, 0).Format(TimeFormat)
+			}
+		}
+		mutex.Unlock()
+		if ptask == nil || status >= TaskFinished {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if ptask == nil {
+		return nil
+	}
+	if !replayed {
+		files, _ := GetTaskFiles(id, false)
+		stream.lines(StreamStdout, files[TExtOut])
+		stream.lines(StreamLog, files[TExtLog])
+	}
+	stream.status(status, message, finish)
+	return nil
+}
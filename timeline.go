@@ -0,0 +1,108 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+	"github.com/labstack/echo/v4"
+)
+
+// TimelineEvent is the output event of the task with the time since the start of the task.
+// The fields of the event are the same as in WsCmd so the web page can replay it.
+type TimelineEvent struct {
+	Time    int64  `json:"t"` // in milliseconds
+	Cmd     int    `json:"cmd"`
+	Status  int    `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+	Finish  string `json:"finish,omitempty"`
+	Ref     string `json:"ref,omitempty"`
+}
+
+type TimelineResponse struct {
+	List  []TimelineEvent `json:"list"`
+	Error string          `json:"error,omitempty"`
+}
+
+var (
+	timelineFile  *os.File
+	timelineStart time.Time
+	timelineMutex = &sync.Mutex{}
+)
+
+// recordEvent appends the command sent to the web page to the timeline of the task
+func recordEvent(cmd WsCmd) {
+	timelineMutex.Lock()
+	defer timelineMutex.Unlock()
+	if timelineFile == nil {
+		return
+	}
+	data, err := json.Marshal(TimelineEvent{
+		// time.Since uses the monotonic clock
+		Time:    time.Since(timelineStart).Milliseconds(),
+		Cmd:     cmd.Cmd,
+		Status:  cmd.Status,
+		Message: cmd.Message,
+		Finish:  cmd.Time,
+		Ref:     cmd.Ref,
+	})
+	if err == nil {
+		_, err = timelineFile.Write(append(data, '\n'))
+	}
+	if err != nil {
+		golog.Error(err)
+	}
+}
+
+func closeTimeline() {
+	timelineMutex.Lock()
+	if timelineFile != nil {
+		timelineFile.Close()
+		timelineFile = nil
+	}
+	timelineMutex.Unlock()
+}
+
+// GetTimeline returns the recorded output events of the task
+func GetTimeline(ptask *Task) ([]TimelineEvent, error) {
+	rc, err := openTaskFile(ptask.ID, fmt.Sprintf(`%08x.%s`, ptask.ID, TaskExt[TExtTimeline]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// the task has been run before the timeline was recorded
+			return []TimelineEvent{}, nil
+		}
+		return nil, err
+	}
+	defer rc.Close()
+	ret := make([]TimelineEvent, 0)
+	dec := json.NewDecoder(rc)
+	for dec.More() {
+		var event TimelineEvent
+		if err = dec.Decode(&event); err != nil {
+			// the last line can be incomplete if the task has crashed
+			break
+		}
+		ret = append(ret, event)
+	}
+	return ret, nil
+}
+
+func timelineHandle(c echo.Context) error {
+	ptask, _, err := showTaskAccess(c, c.Param(`id`))
+	if err != nil || ptask == nil {
+		return err
+	}
+	list, err := GetTimeline(ptask)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusOK, &TimelineResponse{List: list})
+}
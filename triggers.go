@@ -0,0 +1,204 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"eonza/lib"
+	"eonza/users"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kataras/golog"
+	"github.com/labstack/echo/v4"
+)
+
+// TriggerDepth is the maximum length of the chain of the scripts started by triggers
+const TriggerDepth = 8
+
+// Trigger runs the script when the task of the source script has finished
+type Trigger struct {
+	ID       uint32 `json:"id"`
+	Name     string `json:"name"`
+	Source   string `json:"source"`   // the finished script
+	Statuses []int  `json:"statuses"` // final statuses of the source task
+	Script   string `json:"script"`   // the script to run
+	Active   bool   `json:"active"`
+}
+
+// TriggerData is passed as Data to the started script
+type TriggerData struct {
+	TaskID     uint32            `json:"taskid"`
+	Script     string            `json:"script"`
+	Status     int               `json:"status"`
+	StatusName string            `json:"statusname"`
+	Message    string            `json:"message,omitempty"`
+	Results    map[string]string `json:"results,omitempty"`
+}
+
+type TriggersResponse struct {
+	List  []*Trigger `json:"list"`
+	Error string     `json:"error,omitempty"`
+}
+
+func (trigger *Trigger) isStatus(status int) bool {
+	for _, item := range trigger.Statuses {
+		if item == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (trigger *Trigger) Run(ptask *Task, results map[string]string) {
+	if cfg.playground {
+		return
+	}
+	if ptask.Depth >= TriggerDepth {
+		NewNotification(&Notification{
+			Text: fmt.Sprintf(`Trigger error: the chain of triggers is longer than %d scripts`,
+				TriggerDepth),
+			UserID: trigger.ID,
+			RoleID: users.TriggerID,
+			Script: trigger.Script,
+		})
+		return
+	}
+	data, err := json.Marshal(TriggerData{
+		TaskID:     ptask.ID,
+		Script:     ptask.Name,
+		Status:     ptask.Status,
+		StatusName: taskStatusNames[ptask.Status],
+		Message:    ptask.Message,
+		Results:    results,
+	})
+	if err != nil {
+		golog.Error(err)
+		return
+	}
+	rs := RunScript{
+		Name: trigger.Script,
+		Data: string(data),
+		User: users.User{
+			ID:       trigger.ID,
+			Nickname: trigger.Name,
+			RoleID:   users.TriggerID,
+		},
+		Role: users.Role{
+			ID:   users.TriggerID,
+			Name: users.TriggerRole,
+		},
+		IP:    Localhost,
+		Depth: ptask.Depth + 1,
+	}
+	if err := systemRun(&rs); err != nil && err != ErrRunSkipped {
+		NewNotification(&Notification{
+			Text:   fmt.Sprintf(`Trigger error: %s`, err.Error()),
+			UserID: trigger.ID,
+			RoleID: users.TriggerID,
+			Script: rs.Name,
+		})
+	}
+}
+
+// CheckTriggers runs the triggers of the finished task
+func CheckTriggers(ptask *Task, results map[string]string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	name := lib.IdName(ptask.Name)
+	for _, trigger := range storage.Triggers {
+		if trigger.Active && lib.IdName(trigger.Source) == name && trigger.isStatus(ptask.Status) {
+			trigger.Run(ptask, results)
+		}
+	}
+}
+
+func triggersResponse(c echo.Context) error {
+	listInfo := make([]*Trigger, 0, len(storage.Triggers))
+	for _, item := range storage.Triggers {
+		listInfo = append(listInfo, item)
+	}
+	sort.Slice(listInfo, func(i, j int) bool {
+		if listInfo[i].Active != listInfo[j].Active {
+			return listInfo[i].Active
+		}
+		return strings.ToLower(listInfo[i].Name) < strings.ToLower(listInfo[j].Name)
+	})
+	return c.JSON(http.StatusOK, &TriggersResponse{
+		List: listInfo,
+	})
+}
+
+func triggersHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	return triggersResponse(c)
+}
+
+func saveTriggerHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	var trigger Trigger
+	if err := c.Bind(&trigger); err != nil {
+		return jsonError(c, err)
+	}
+	if len(trigger.Source) == 0 {
+		return jsonError(c, Lang(DefLang, `errreq`, `Source`))
+	}
+	if len(trigger.Script) == 0 {
+		return jsonError(c, Lang(DefLang, `errreq`, `Script`))
+	}
+	if lib.IdName(trigger.Source) == lib.IdName(trigger.Script) {
+		return jsonError(c, fmt.Errorf(`the trigger cannot run its source script`))
+	}
+	if len(trigger.Statuses) == 0 {
+		return jsonError(c, Lang(DefLang, `errreq`, `Statuses`))
+	}
+	for _, status := range trigger.Statuses {
+		if status < TaskFinished || status >= len(taskStatusNames) {
+			return jsonError(c, fmt.Errorf(`invalid trigger status %d`, status))
+		}
+	}
+	for _, item := range storage.Triggers {
+		if len(trigger.Name) > 0 && strings.ToLower(trigger.Name) == strings.ToLower(item.Name) &&
+			trigger.ID != item.ID {
+			return jsonError(c, fmt.Errorf(`Trigger '%s' exists`, trigger.Name))
+		}
+	}
+	if trigger.ID == 0 {
+		for {
+			trigger.ID = lib.RndNum()
+			if _, ok := storage.Triggers[trigger.ID]; !ok {
+				break
+			}
+		}
+	} else if _, ok := storage.Triggers[trigger.ID]; !ok {
+		return jsonError(c, fmt.Errorf(`Access denied`))
+	}
+	storage.Triggers[trigger.ID] = &trigger
+	if err := SaveStorage(); err != nil {
+		return jsonError(c, err)
+	}
+	return triggersResponse(c)
+}
+
+func removeTriggerHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if _, ok := storage.Triggers[uint32(id)]; ok {
+		delete(storage.Triggers, uint32(id))
+		if err := SaveStorage(); err != nil {
+			return jsonError(c, err)
+		}
+	}
+	return triggersResponse(c)
+}
@@ -19,7 +19,9 @@ const (
 	EventsRole  = `events`
 	ScriptsRole = `scripts`
 	BrowserRole = `browser`
+	TriggerRole = `triggers`
 	ResRoleID   = 0xffffff00
+	TriggerID   = 0xfffffffb
 	BrowserID   = 0xfffffffc
 	ScriptsID   = 0xfffffffd
 	EventsID    = 0xfffffffe
@@ -77,6 +79,7 @@ func InitUsers(psw []byte, counter uint32) (map[uint32]Role, map[uint32]User) {
 		EventsID:  {ID: EventsID, Name: EventsRole},
 		ScriptsID: {ID: ScriptsID, Name: ScriptsRole},
 		BrowserID: {ID: BrowserID, Name: BrowserRole},
+		TriggerID: {ID: TriggerID, Name: TriggerRole},
 	}
 	Users := map[uint32]User{
 		XRootID: {ID: XRootID, Nickname: RootUser, PasswordHash: psw, RoleID: XAdminID,
@@ -0,0 +1,190 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+const (
+	// WatchdogInterval is the interval of checking task timeouts
+	WatchdogInterval = 5 * time.Second
+	// KillTimeout is the default grace period of the task and the time between the forced
+	// termination and the hard kill of the process
+	KillTimeout = 10 * time.Second
+)
+
+var (
+	processes    = make(map[uint32]*os.Process)
+	processMutex = &sync.Mutex{}
+)
+
+// WatchProcess keeps the process of the task and waits for its exit
+func WatchProcess(taskID uint32, command *exec.Cmd) {
+	processMutex.Lock()
+	processes[taskID] = command.Process
+	processMutex.Unlock()
+	go func() {
+		command.Wait()
+		processMutex.Lock()
+		delete(processes, taskID)
+		processMutex.Unlock()
+		os.Remove(taskSocket(taskID))
+		os.Remove(localSocket(taskID))
+		mutex.Lock()
+		defer mutex.Unlock()
+		ptask, ok := tasks[taskID]
+		if !ok {
+			return
+		}
+		if command.ProcessState != nil {
+			ptask.Usage = processUsage(command.ProcessState)
+		}
+		if ptask.Status < TaskFinished {
+			// the process has exited without sending the final status
+			if err := SetTaskStatus(TaskStatus{
+				TaskID:  taskID,
+				Status:  TaskCrashed,
+				Message: `the task process has exited unexpectedly`,
+				Time:    time.Now().Unix(),
+			}); err != nil {
+				golog.Error(err)
+			}
+		} else if ptask.Usage != nil {
+			// SaveTrace has been already called for the final status
+			if err := appendHistory(taskEntry{Task: ptask}); err != nil {
+				golog.Error(err)
+			}
+		}
+	}()
+}
+
+// KillTask kills the local process of the task. It returns false if the process is unknown.
+func KillTask(taskID uint32) bool {
+	processMutex.Lock()
+	process := processes[taskID]
+	processMutex.Unlock()
+	if process == nil {
+		return false
+	}
+	if err := process.Kill(); err != nil {
+		golog.Error(err)
+	}
+	return true
+}
+
+func (ptask *Task) trackWaiting(status int) {
+	now := time.Now().Unix()
+	if status == TaskWaiting && ptask.waitStart == 0 {
+		ptask.waitStart = now
+	} else if status != TaskWaiting && ptask.waitStart != 0 {
+		ptask.waited += now - ptask.waitStart
+		ptask.waitStart = 0
+	}
+}
+
+// taskTimeouts returns the run and wait timeouts of the task in seconds
+func taskTimeouts(ptask *Task) (timeout int64, wait int64) {
+	timeout = int64(storage.Settings.TaskTimeout)
+	wait = int64(storage.Settings.WaitTimeout)
+	if item := getRunScript(ptask.Name); item != nil {
+		if item.Settings.Timeout > 0 {
+			timeout = int64(item.Settings.Timeout)
+		}
+		if item.Settings.WaitTimeout > 0 {
+			wait = int64(item.Settings.WaitTimeout)
+		}
+	}
+	return timeout * 60, wait * 60
+}
+
+// gracePeriod returns the time which the task has for the finally blocks after the cancel request
+func gracePeriod(item *Script) time.Duration {
+	if item != nil && item.Settings.GracePeriod > 0 {
+		return time.Duration(item.Settings.GracePeriod) * time.Second
+	}
+	return KillTimeout
+}
+
+// CancelTask sends the terminate command to the task. The task runs the finally blocks and
+// terminates itself after the grace period. The process is killed if it has not exited
+// during KillTimeout after that.
+func CancelTask(ptask *Task) {
+	go TerminateTask(ptask)
+	time.AfterFunc(gracePeriod(getRunScript(ptask.Name))+KillTimeout, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if ptask.Status >= TaskFinished {
+			return
+		}
+		if len(ptask.Agent) > 0 {
+			// the agent is requested without locking other requests
+			mutex.Unlock()
+			killed := killAgentTask(ptask)
+			mutex.Lock()
+			if killed || ptask.Status >= TaskFinished {
+				// the agent sends the final status after the exit of the process
+				return
+			}
+		} else if KillTask(ptask.ID) {
+			// WatchProcess sets the final status after the exit of the process
+			return
+		}
+		status := TaskTerminated
+		if len(ptask.timeout) > 0 {
+			status = TaskTimeout
+		}
+		if err := SetTaskStatus(TaskStatus{
+			TaskID:  ptask.ID,
+			Status:  status,
+			Message: ptask.timeout,
+			Time:    time.Now().Unix(),
+		}); err != nil {
+			golog.Error(err)
+		}
+	})
+}
+
+// TimeoutTask cancels the task which has exceeded the timeout
+func TimeoutTask(ptask *Task, message string) {
+	ptask.timeout = message
+	CancelTask(ptask)
+}
+
+// checkTimeouts cancels the overdue tasks, the global mutex must be locked
+func checkTimeouts() {
+	now := time.Now().Unix()
+	for _, ptask := range ListTasks() {
+		if ptask.Status >= TaskFinished || len(ptask.timeout) > 0 {
+			continue
+		}
+		timeout, wait := taskTimeouts(ptask)
+		if ptask.Status == TaskWaiting && ptask.waitStart != 0 {
+			if wait > 0 && now-ptask.waitStart > wait {
+				TimeoutTask(ptask, fmt.Sprintf(`the form has been waiting longer than %d min`,
+					wait/60))
+			}
+			continue
+		}
+		if timeout > 0 && now-ptask.StartTime-ptask.waited > timeout {
+			TimeoutTask(ptask, fmt.Sprintf(`the task has been running longer than %d min`,
+				timeout/60))
+		}
+	}
+}
+
+func taskWatchdog() {
+	for range time.Tick(WatchdogInterval) {
+		mutex.Lock()
+		checkTimeouts()
+		mutex.Unlock()
+	}
+}
@@ -0,0 +1,463 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"eonza/lib"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// WebhookAttempts is the maximum number of delivery attempts
+	WebhookAttempts = 4
+	// WebhookDelay is the delay before the first retry in seconds, it is tripled for each retry
+	WebhookDelay = 10
+	// WebhookTimeout is the timeout of the delivery request in seconds
+	WebhookTimeout = 15
+	// DeliveryLimit is the maximum number of records in the delivery log
+	DeliveryLimit = 200
+	// DeliveryLog is the file name of the delivery log
+	DeliveryLog = `deliveries.log`
+)
+
+const ( // The events of the task lifecycle
+	HookStart      = `start`
+	HookWaiting    = `waiting`
+	HookFinished   = `finished`
+	HookFailed     = `failed`
+	HookTerminated = `terminated`
+	HookCrashed    = `crashed`
+	HookTimeout    = `timeout`
+)
+
+var hookEvents = map[int]string{
+	TaskActive:     HookStart,
+	TaskWaiting:    HookWaiting,
+	TaskFinished:   HookFinished,
+	TaskFailed:     HookFailed,
+	TaskTerminated: HookTerminated,
+	TaskCrashed:    HookCrashed,
+	TaskTimeout:    HookTimeout,
+}
+
+// Webhook sends the task lifecycle events to the external URL
+type Webhook struct {
+	ID      uint32   `json:"id"`
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`            // the key of HMAC-SHA256 signature
+	Events  []string `json:"events"`            // empty - all events
+	Scripts []string `json:"scripts,omitempty"` // empty - any script
+	Roles   []uint32 `json:"roles,omitempty"`   // empty - any role
+	Active  bool     `json:"active"`
+}
+
+// WebhookData is the JSON body of the webhook request
+type WebhookData struct {
+	Event      string   `json:"event"`
+	TaskID     uint32   `json:"taskid"`
+	Name       string   `json:"name"`
+	User       string   `json:"user"`
+	Role       string   `json:"role"`
+	Status     int      `json:"status"`
+	StatusName string   `json:"statusname"`
+	Message    string   `json:"message,omitempty"`
+	StartTime  int64    `json:"start"`
+	FinishTime int64    `json:"finish,omitempty"`
+	Time       int64    `json:"time"`
+	Reports    []string `json:"reports,omitempty"` // the titles of the reports
+}
+
+// Delivery is the record of the delivery log
+type Delivery struct {
+	HookID  uint32 `json:"hookid"`
+	Hook    string `json:"hook"`
+	TaskID  uint32 `json:"taskid"`
+	Event   string `json:"event"`
+	Attempt int    `json:"attempt"`
+	Time    string `json:"time"`
+	Code    int    `json:"code,omitempty"` // HTTP status code of the response
+	Error   string `json:"error,omitempty"`
+}
+
+type WebhooksResponse struct {
+	List  []*Webhook `json:"list"`
+	Error string     `json:"error,omitempty"`
+}
+
+type DeliveriesResponse struct {
+	List  []Delivery `json:"list"`
+	Error string     `json:"error,omitempty"`
+}
+
+var (
+	deliveries   = make([]Delivery, 0, DeliveryLimit)
+	deliveryLock = &sync.Mutex{}
+	// deliveryCount is the number of records appended to DeliveryLog after its last rewrite
+	deliveryCount int
+	hookClient    = &http.Client{Timeout: WebhookTimeout * time.Second}
+)
+
+func (hook *Webhook) match(ptask *Task, event string) bool {
+	if !hook.Active {
+		return false
+	}
+	if len(hook.Events) > 0 {
+		var ok bool
+		for _, item := range hook.Events {
+			if ok = item == event; ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(hook.Scripts) > 0 {
+		var ok bool
+		name := lib.IdName(ptask.Name)
+		for _, item := range hook.Scripts {
+			if ok = lib.IdName(item) == name; ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(hook.Roles) > 0 {
+		for _, item := range hook.Roles {
+			if item == ptask.RoleID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of the body
+func (hook *Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliveryPath() string {
+	return filepath.Join(cfg.Log.Dir, DeliveryLog)
+}
+
+func pushDelivery(delivery Delivery) {
+	if len(deliveries) >= DeliveryLimit {
+		deliveries = append(deliveries[:0], deliveries[1:]...)
+	}
+	deliveries = append(deliveries, delivery)
+}
+
+// LoadDeliveries loads the last DeliveryLimit records of the delivery log
+func LoadDeliveries() error {
+	f, err := os.Open(deliveryPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	for scanner.Scan() {
+		var delivery Delivery
+		if err = json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			// the last line can be broken if the application has crashed while writing
+			continue
+		}
+		pushDelivery(delivery)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return writeDeliveries()
+}
+
+// writeDeliveries rewrites the delivery log with the records in the memory
+func writeDeliveries() error {
+	var out bytes.Buffer
+	for _, item := range deliveries {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		out.Write(append(data, '\n'))
+	}
+	deliveryCount = 0
+	return os.WriteFile(deliveryPath(), out.Bytes(), 0666)
+}
+
+func appendDelivery(delivery Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(deliveryPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// logDelivery adds the record to the delivery log. The file is truncated to the last
+// DeliveryLimit records when the same number of records has been appended.
+func logDelivery(delivery Delivery) {
+	var err error
+
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	pushDelivery(delivery)
+	if deliveryCount++; deliveryCount >= DeliveryLimit {
+		err = writeDeliveries()
+	} else {
+		err = appendDelivery(delivery)
+	}
+	if err != nil {
+		golog.Error(err)
+	}
+}
+
+func (hook *Webhook) post(event string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(`X-Eonza-Event`, event)
+	if len(hook.Secret) > 0 {
+		req.Header.Set(`X-Eonza-Signature`, `sha256=`+hook.Sign(body))
+	}
+	resp, err := hookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf(`unexpected response %s`, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliver sends the request and retries it if it has failed
+func (hook *Webhook) deliver(data *WebhookData) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		logDelivery(Delivery{
+			HookID: hook.ID,
+			Hook:   hook.Name,
+			TaskID: data.TaskID,
+			Event:  data.Event,
+			Time:   time.Now().Format(TimeFormat),
+			Error:  err.Error(),
+		})
+		return
+	}
+	delay := WebhookDelay * time.Second
+	for attempt := 1; attempt <= WebhookAttempts; attempt++ {
+		code, err := hook.post(data.Event, body)
+		delivery := Delivery{
+			HookID:  hook.ID,
+			Hook:    hook.Name,
+			TaskID:  data.TaskID,
+			Event:   data.Event,
+			Attempt: attempt,
+			Time:    time.Now().Format(TimeFormat),
+			Code:    code,
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		logDelivery(delivery)
+		if err == nil {
+			break
+		}
+		if attempt < WebhookAttempts {
+			time.Sleep(delay)
+			delay *= 3
+		}
+	}
+}
+
+// SendWebhooks sends the event of the task to the matching webhooks
+func SendWebhooks(ptask *Task, event string) {
+	if cfg.playground || len(event) == 0 {
+		return
+	}
+	// the webhooks are copied because they are delivered without the global mutex
+	list := make([]Webhook, 0)
+	for _, hook := range storage.Webhooks {
+		if hook.match(ptask, event) {
+			list = append(list, *hook)
+		}
+	}
+	if len(list) == 0 {
+		return
+	}
+	userName, roleName := GetUserRole(ptask.UserID, ptask.RoleID)
+	data := WebhookData{
+		Event:      event,
+		TaskID:     ptask.ID,
+		Name:       ptask.Name,
+		User:       userName,
+		Role:       roleName,
+		Status:     ptask.Status,
+		StatusName: taskStatusNames[ptask.Status],
+		Message:    ptask.Message,
+		StartTime:  ptask.StartTime,
+		FinishTime: ptask.FinishTime,
+		Time:       time.Now().Unix(),
+	}
+	go func() {
+		if data.Status >= TaskFinished {
+			_, replist := GetTaskFiles(data.TaskID, false)
+			for _, rep := range replist {
+				data.Reports = append(data.Reports, rep.Title)
+			}
+		}
+		for i := range list {
+			go list[i].deliver(&data)
+		}
+	}()
+}
+
+func webhooksResponse(c echo.Context) error {
+	listInfo := make([]*Webhook, 0, len(storage.Webhooks))
+	for _, item := range storage.Webhooks {
+		hook := *item
+		if len(hook.Secret) > 0 {
+			hook.Secret = MaskedValue
+		}
+		listInfo = append(listInfo, &hook)
+	}
+	sort.Slice(listInfo, func(i, j int) bool {
+		if listInfo[i].Active != listInfo[j].Active {
+			return listInfo[i].Active
+		}
+		return strings.ToLower(listInfo[i].Name) < strings.ToLower(listInfo[j].Name)
+	})
+	return c.JSON(http.StatusOK, &WebhooksResponse{
+		List: listInfo,
+	})
+}
+
+func webhooksHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	return webhooksResponse(c)
+}
+
+func saveWebhookHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	var hook Webhook
+	if err := c.Bind(&hook); err != nil {
+		return jsonError(c, err)
+	}
+	if len(hook.URL) == 0 {
+		return jsonError(c, Lang(DefLang, `errreq`, `URL`))
+	}
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != `http` && u.Scheme != `https`) {
+		return jsonError(c, fmt.Errorf(`invalid webhook URL %s`, hook.URL))
+	}
+	for _, event := range hook.Events {
+		var ok bool
+		for _, item := range hookEvents {
+			if ok = item == event; ok {
+				break
+			}
+		}
+		if !ok {
+			return jsonError(c, fmt.Errorf(`invalid webhook event %s`, event))
+		}
+	}
+	for _, item := range storage.Webhooks {
+		if len(hook.Name) > 0 && strings.ToLower(hook.Name) == strings.ToLower(item.Name) &&
+			hook.ID != item.ID {
+			return jsonError(c, fmt.Errorf(`Webhook '%s' exists`, hook.Name))
+		}
+	}
+	if hook.ID == 0 {
+		for {
+			hook.ID = lib.RndNum()
+			if _, ok := storage.Webhooks[hook.ID]; !ok {
+				break
+			}
+		}
+	} else if prev, ok := storage.Webhooks[hook.ID]; !ok {
+		return jsonError(c, fmt.Errorf(`Access denied`))
+	} else if hook.Secret == MaskedValue {
+		// the secret has not been changed
+		hook.Secret = prev.Secret
+	}
+	storage.Webhooks[hook.ID] = &hook
+	if err := SaveStorage(); err != nil {
+		return jsonError(c, err)
+	}
+	return webhooksResponse(c)
+}
+
+func removeWebhookHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if _, ok := storage.Webhooks[uint32(id)]; ok {
+		delete(storage.Webhooks, uint32(id))
+		if err := SaveStorage(); err != nil {
+			return jsonError(c, err)
+		}
+	}
+	return webhooksResponse(c)
+}
+
+// deliveriesHandle returns the last DeliveryLimit records of the delivery log, the newest records
+// go first. The log can be filtered by the webhook with id parameter.
+func deliveriesHandle(c echo.Context) error {
+	if err := CheckAdmin(c); err != nil {
+		return jsonError(c, err)
+	}
+	id, _ := strconv.ParseUint(c.QueryParam("id"), 10, 32)
+	list := make([]Delivery, 0)
+	deliveryLock.Lock()
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		if id == 0 || deliveries[i].HookID == uint32(id) {
+			list = append(list, deliveries[i])
+		}
+	}
+	deliveryLock.Unlock()
+	return c.JSON(http.StatusOK, &DeliveriesResponse{
+		List: list,
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Alexey Krivonogov. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookSign(t *testing.T) {
+	for _, test := range []struct {
+		secret string
+		body   string
+		want   string
+	}{
+		// RFC 4231, test case 2
+		{`Jefe`, `what do ya want for nothing?`,
+			`5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843`},
+		{`key`, `The quick brown fox jumps over the lazy dog`,
+			`f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8`},
+		{``, ``, `b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad`},
+	} {
+		hook := Webhook{Secret: test.secret}
+		if got := hook.Sign([]byte(test.body)); got != test.want {
+			t.Errorf(`%q %q: %s != %s`, test.secret, test.body, got, test.want)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":"finished"}`)
+	for _, secret := range []string{``, `secret`} {
+		var signature, event string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			signature = r.Header.Get(`X-Eonza-Signature`)
+			event = r.Header.Get(`X-Eonza-Event`)
+			if data, err := io.ReadAll(r.Body); err != nil || string(data) != string(body) {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		hook := Webhook{URL: server.URL, Secret: secret}
+		code, err := hook.post(HookFinished, body)
+		server.Close()
+		if err != nil || code != http.StatusOK {
+			t.Errorf(`%q: %d %v`, secret, code, err)
+			continue
+		}
+		want := ``
+		if len(secret) > 0 {
+			want = `sha256=` + hook.Sign(body)
+		}
+		if signature != want || event != HookFinished {
+			t.Errorf(`%q: %q %q`, secret, signature, event)
+		}
+	}
+}
+
+func TestWebhookMatch(t *testing.T) {
+	task := Task{Name: `my-backup`, RoleID: 2}
+	for _, test := range []struct {
+		hook  Webhook
+		event string
+		want  bool
+	}{
+		{Webhook{Active: true}, HookStart, true},
+		{Webhook{}, HookStart, false},
+		{Webhook{Active: true, Events: []string{HookFailed, HookCrashed}}, HookFailed, true},
+		{Webhook{Active: true, Events: []string{HookFailed, HookCrashed}}, HookFinished, false},
+		{Webhook{Active: true, Scripts: []string{`my.backup`}}, HookStart, true},
+		{Webhook{Active: true, Scripts: []string{`restore`}}, HookStart, false},
+		{Webhook{Active: true, Roles: []uint32{1, 2}}, HookStart, true},
+		{Webhook{Active: true, Roles: []uint32{1}}, HookStart, false},
+	} {
+		if got := test.hook.match(&task, test.event); got != test.want {
+			t.Errorf(`%+v %s: %v != %v`, test.hook, test.event, got, test.want)
+		}
+	}
+}